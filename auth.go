@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// writeCredentials writes the netrc entries, the terraform CLI config with the private
+// registry tokens and the SSH key for the modules, returning a function that removes
+// them again
+func (p Plugin) writeCredentials() (func(), error) {
+	var cleanups []func()
+	cleanup := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+
+	entries := append([]Netrc{p.Netrc}, p.Config.NetrcEntries...)
+	if hasNetrcEntries(entries) {
+		path := filepath.Join(homeDir(), ".netrc")
+		restore, err := backupFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, restore)
+
+		if err := writeNetrc(path, entries); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("Failed to write netrc file. %s", err)
+		}
+	}
+
+	if len(p.Config.RegistryTokens) == 0 && p.Config.SSHKey == "" {
+		return cleanup, nil
+	}
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("Failed to write credentials. %s", err)
+	}
+	cleanups = append(cleanups, func() { os.RemoveAll(dir) })
+
+	if len(p.Config.RegistryTokens) > 0 {
+		path := filepath.Join(dir, "terraformrc")
+		if err := writeTerraformRC(path, p.cliConfig(), p.Config.RegistryTokens); err != nil {
+			cleanup()
+			return nil, err
+		}
+		p.env.Set("TF_CLI_CONFIG_FILE", path)
+	}
+
+	if p.Config.SSHKey != "" {
+		command, err := writeSSHKey(dir, p.Config.SSHKey, p.Config.SSHKnownHosts, p.Config.SSHSkipHostKeys)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		p.env.Set("GIT_SSH_COMMAND", command)
+	}
+
+	return cleanup, nil
+}
+
+func hasNetrcEntries(entries []Netrc) bool {
+	for _, e := range entries {
+		if e.Machine != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backupFile returns a function restoring the file as it is now, or removing it when it
+// does not exist yet
+func backupFile(path string) (func(), error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return func() { os.Remove(path) }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := ioutil.WriteFile(path, b, info.Mode()); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"path":  path,
+				"error": err,
+			}).Warn("Failed to restore file")
+		}
+	}, nil
+}
+
+// cliConfig returns the path of the terraform CLI config in use, so the generated config
+// keeps its settings
+func (p Plugin) cliConfig() string {
+	if path := p.env.terraform["TF_CLI_CONFIG_FILE"]; path != "" {
+		return path
+	}
+	if path := os.Getenv("TF_CLI_CONFIG_FILE"); path != "" {
+		return path
+	}
+
+	return filepath.Join(homeDir(), ".terraformrc")
+}
+
+// writeTerraformRC writes the CLI config with a credentials block for every registry
+// host, after the contents of the current config
+func writeTerraformRC(path, current string, tokens map[string]string) error {
+	var b bytes.Buffer
+	if existing, err := ioutil.ReadFile(current); err == nil {
+		b.Write(bytes.TrimSpace(existing))
+		b.WriteString("\n\n")
+	}
+
+	hosts := make([]string, 0, len(tokens))
+	for host := range tokens {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		b.WriteString(fmt.Sprintf("credentials %q {\n  token = %q\n}\n\n", host, tokens[host]))
+	}
+
+	if err := ioutil.WriteFile(path, b.Bytes(), 0600); err != nil {
+		return fmt.Errorf("Failed to write terraform CLI config. %s", err)
+	}
+
+	return nil
+}
+
+// writeSSHKey writes the deploy key, and the known hosts when given, returning the
+// GIT_SSH_COMMAND using them. Without known hosts, the host keys are checked against the
+// system known hosts, unless checking them is explicitly skipped.
+func writeSSHKey(dir, key, knownHosts string, skipHostKeyCheck bool) (string, error) {
+	keyPath := filepath.Join(dir, "id_deploy")
+	// ssh rejects keys without a trailing newline
+	if err := ioutil.WriteFile(keyPath, []byte(strings.TrimSpace(key)+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("Failed to write SSH key. %s", err)
+	}
+
+	command := fmt.Sprintf("ssh -i '%s' -o IdentitiesOnly=yes", keyPath)
+	if skipHostKeyCheck {
+		logrus.Warn("Not checking the host keys of module sources")
+		return command + " -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null", nil
+	}
+	if knownHosts == "" {
+		return command + " -o StrictHostKeyChecking=yes", nil
+	}
+
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(knownHostsPath, []byte(strings.TrimSpace(knownHosts)+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("Failed to write SSH known hosts. %s", err)
+	}
+
+	return command + fmt.Sprintf(" -o StrictHostKeyChecking=yes -o UserKnownHostsFile='%s'", knownHostsPath), nil
+}
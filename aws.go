@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	defaultRoleSessionName = "drone"
+	defaultRoleDuration    = time.Hour
+)
+
+type (
+	// RoleOptions configure how the roles are assumed
+	RoleOptions struct {
+		SessionName string
+		ExternalID  string
+		Duration    time.Duration
+		Tags        map[string]string
+
+		// WebIdentityToken assumes the first role with AssumeRoleWithWebIdentity
+		WebIdentityToken string
+	}
+)
+
+// assumeRole assumes the configured role, and then the chained roles, passing the
+// credentials of the last one to terraform
+func (p Plugin) assumeRole() error {
+	// STS is called by the plugin itself, which does not read SSL_CERT_FILE
+	transport, err := caTransport(p.Config.caBundle)
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewSession(&aws.Config{HTTPClient: &http.Client{Transport: transport}})
+	if err != nil {
+		return fmt.Errorf("Failed to create AWS session. %s", err)
+	}
+
+	token, err := p.webIdentityToken()
+	if err != nil {
+		return err
+	}
+
+	roles := append([]string{p.Config.RoleARN}, p.Config.RoleChain...)
+	value, err := assumeRoles(sess, roles, RoleOptions{
+		SessionName:      p.Config.RoleSessionName,
+		ExternalID:       p.Config.RoleExternalID,
+		Duration:         p.Config.RoleDuration,
+		Tags:             p.Config.RoleTags,
+		WebIdentityToken: token,
+	})
+	if err != nil {
+		return err
+	}
+
+	p.env.Set("AWS_ACCESS_KEY_ID", value.AccessKeyID)
+	p.env.Set("AWS_SECRET_ACCESS_KEY", value.SecretAccessKey)
+	p.env.Set("AWS_SESSION_TOKEN", value.SessionToken)
+
+	return nil
+}
+
+// webIdentityToken returns the OIDC token from the config, or read from the token file
+func (p Plugin) webIdentityToken() (string, error) {
+	if p.Config.RoleToken != "" || p.Config.RoleTokenFile == "" {
+		return strings.TrimSpace(p.Config.RoleToken), nil
+	}
+
+	b, err := ioutil.ReadFile(p.Config.RoleTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read web identity token file. %s", err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// assumeRoles assumes every role with the credentials of the previous one, starting
+// with the credentials of the session, or with the web identity token. The external ID
+// and the session tags are passed to the first AssumeRole call, and the tags are
+// transitive so they apply to the rest of the chain.
+func assumeRoles(sess *session.Session, roles []string, opts RoleOptions) (credentials.Value, error) {
+	if opts.SessionName == "" {
+		opts.SessionName = defaultRoleSessionName
+	}
+	if opts.Duration == 0 {
+		opts.Duration = defaultRoleDuration
+	}
+
+	var tags []*sts.Tag
+	var tagKeys []*string
+	for _, k := range sortedTagKeys(opts.Tags) {
+		tags = append(tags, &sts.Tag{Key: aws.String(k), Value: aws.String(opts.Tags[k])})
+		tagKeys = append(tagKeys, aws.String(k))
+	}
+
+	var value credentials.Value
+	first := true
+	for i, role := range roles {
+		var err error
+		if i == 0 && opts.WebIdentityToken != "" {
+			value, err = assumeRoleWithWebIdentity(sess, role, opts)
+		} else {
+			provider := &stscreds.AssumeRoleProvider{
+				Client:          sts.New(sess),
+				RoleARN:         role,
+				RoleSessionName: opts.SessionName,
+				Duration:        opts.Duration,
+			}
+			if first {
+				if opts.ExternalID != "" {
+					provider.ExternalID = aws.String(opts.ExternalID)
+				}
+				provider.Tags = tags
+				if i < len(roles)-1 {
+					provider.TransitiveTagKeys = tagKeys
+				}
+				first = false
+			}
+			value, err = credentials.NewCredentials(provider).Get()
+		}
+		if err != nil {
+			return credentials.Value{}, fmt.Errorf("Failed to assume role %s. %s", role, err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"role":    role,
+			"session": opts.SessionName,
+		}).Info("Assumed role")
+
+		sess = sess.Copy(&aws.Config{
+			Credentials: credentials.NewStaticCredentialsFromCreds(value),
+		})
+	}
+
+	return value, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the OIDC token for the credentials of the role,
+// which needs no other credentials
+func assumeRoleWithWebIdentity(sess *session.Session, role string, opts RoleOptions) (credentials.Value, error) {
+	out, err := sts.New(sess).AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(role),
+		RoleSessionName:  aws.String(opts.SessionName),
+		WebIdentityToken: aws.String(opts.WebIdentityToken),
+		DurationSeconds:  aws.Int64(int64(opts.Duration / time.Second)),
+	})
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(out.Credentials.SessionToken),
+		ProviderName:    stscreds.WebIdentityProviderName,
+	}, nil
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
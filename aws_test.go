@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/franela/goblin"
+)
+
+// fakeSTS answers AssumeRole with credentials named after the call, recording the form
+// and the access key the request was signed with
+type fakeSTS struct {
+	requests []url.Values
+	signedBy []string
+	fail     bool
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.requests = append(f.requests, r.PostForm)
+
+	// AssumeRoleWithWebIdentity requests are not signed
+	auth := r.Header.Get("Authorization")
+	if i := strings.Index(auth, "Credential="); i >= 0 {
+		credential := auth[i+len("Credential="):]
+		f.signedBy = append(f.signedBy, credential[:strings.Index(credential, "/")])
+	} else {
+		f.signedBy = append(f.signedBy, "")
+	}
+
+	if f.fail {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not authorized</Message></Error></ErrorResponse>`)
+		return
+	}
+
+	n := len(f.requests)
+	action := r.PostForm.Get("Action")
+	fmt.Fprintf(w, `<%sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%sResult>
+    <Credentials>
+      <AccessKeyId>ASIAKEY%d</AccessKeyId>
+      <SecretAccessKey>secret%d</SecretAccessKey>
+      <SessionToken>token%d</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </%sResult>
+</%sResponse>`, action, action, n, n, n, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), action, action)
+}
+
+func TestAssumeRoles(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("webIdentityToken", func() {
+		g.It("prefers the token over the token file", func() {
+			p := Plugin{Config: Config{RoleToken: "token\n", RoleTokenFile: "/nonexistent"}}
+			token, err := p.webIdentityToken()
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(token).Equal("token")
+		})
+
+		g.It("reads the token file", func() {
+			file, _ := ioutil.TempFile("", "token")
+			defer os.Remove(file.Name())
+			file.WriteString("file-token\n")
+			file.Close()
+
+			p := Plugin{Config: Config{RoleTokenFile: file.Name()}}
+			token, err := p.webIdentityToken()
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(token).Equal("file-token")
+		})
+
+		g.It("fails on missing token files", func() {
+			p := Plugin{Config: Config{RoleTokenFile: "/nonexistent/token"}}
+			_, err := p.webIdentityToken()
+			g.Assert(err != nil).IsTrue("should have received error")
+		})
+	})
+
+	g.Describe("assumeRoles", func() {
+		var fake *fakeSTS
+		var server *httptest.Server
+		var sess *session.Session
+
+		g.BeforeEach(func() {
+			fake = &fakeSTS{}
+			server = httptest.NewServer(fake)
+			sess = session.Must(session.NewSession(&aws.Config{
+				Endpoint:    aws.String(server.URL),
+				Region:      aws.String("us-east-1"),
+				Credentials: credentials.NewStaticCredentials("AKIABASE", "base", ""),
+				MaxRetries:  aws.Int(0),
+			}))
+		})
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("assumes a role with the options", func() {
+			value, err := assumeRoles(sess, []string{"arn:aws:iam::111111111111:role/a"}, RoleOptions{
+				SessionName: "pipeline",
+				ExternalID:  "shared",
+				Duration:    15 * time.Minute,
+				Tags:        map[string]string{"repo": "infra", "build": "42"},
+			})
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(value.AccessKeyID).Equal("ASIAKEY1")
+			g.Assert(value.SessionToken).Equal("token1")
+
+			form := fake.requests[0]
+			g.Assert(form.Get("Action")).Equal("AssumeRole")
+			g.Assert(form.Get("RoleArn")).Equal("arn:aws:iam::111111111111:role/a")
+			g.Assert(form.Get("RoleSessionName")).Equal("pipeline")
+			g.Assert(form.Get("ExternalId")).Equal("shared")
+			g.Assert(form.Get("DurationSeconds")).Equal("900")
+			g.Assert(form.Get("Tags.member.1.Key")).Equal("build")
+			g.Assert(form.Get("Tags.member.2.Value")).Equal("infra")
+			g.Assert(form.Get("TransitiveTagKeys.member.1")).Equal("")
+		})
+
+		g.It("defaults the session name and duration", func() {
+			_, err := assumeRoles(sess, []string{"arn:aws:iam::111111111111:role/a"}, RoleOptions{})
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(fake.requests[0].Get("RoleSessionName")).Equal("drone")
+			g.Assert(fake.requests[0].Get("DurationSeconds")).Equal("3600")
+			g.Assert(fake.requests[0].Get("ExternalId")).Equal("")
+		})
+
+		g.It("assumes chained roles with the previous credentials", func() {
+			value, err := assumeRoles(sess, []string{"arn:aws:iam::111111111111:role/a", "arn:aws:iam::222222222222:role/b"}, RoleOptions{
+				ExternalID: "shared",
+				Tags:       map[string]string{"repo": "infra"},
+			})
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(value.AccessKeyID).Equal("ASIAKEY2")
+			g.Assert(fake.signedBy).Equal([]string{"AKIABASE", "ASIAKEY1"})
+			g.Assert(fake.requests[0].Get("TransitiveTagKeys.member.1")).Equal("repo")
+			g.Assert(fake.requests[1].Get("RoleArn")).Equal("arn:aws:iam::222222222222:role/b")
+			g.Assert(fake.requests[1].Get("ExternalId")).Equal("")
+			g.Assert(fake.requests[1].Get("Tags.member.1.Key")).Equal("")
+		})
+
+		g.It("assumes the first role with a web identity token", func() {
+			value, err := assumeRoles(sess, []string{"arn:aws:iam::111111111111:role/oidc", "arn:aws:iam::222222222222:role/b"}, RoleOptions{
+				ExternalID:       "shared",
+				Duration:         30 * time.Minute,
+				WebIdentityToken: "eyJ.token",
+			})
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(value.AccessKeyID).Equal("ASIAKEY2")
+			g.Assert(fake.signedBy).Equal([]string{"", "ASIAKEY1"})
+
+			form := fake.requests[0]
+			g.Assert(form.Get("Action")).Equal("AssumeRoleWithWebIdentity")
+			g.Assert(form.Get("RoleArn")).Equal("arn:aws:iam::111111111111:role/oidc")
+			g.Assert(form.Get("WebIdentityToken")).Equal("eyJ.token")
+			g.Assert(form.Get("DurationSeconds")).Equal("1800")
+			g.Assert(fake.requests[1].Get("Action")).Equal("AssumeRole")
+			g.Assert(fake.requests[1].Get("ExternalId")).Equal("shared")
+		})
+
+		g.It("returns errors", func() {
+			fake.fail = true
+			_, err := assumeRoles(sess, []string{"arn:aws:iam::111111111111:role/a"}, RoleOptions{})
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(strings.Contains(err.Error(), "AccessDenied")).IsTrue("should include the STS error")
+		})
+	})
+}
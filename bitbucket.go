@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	bitbucketAPIPath     = "rest/api/1.0/"
+	bitbucketPageLimit   = 100
+	bitbucketPropertyKey = "drone-terraform-github-commenter"
+)
+
+var (
+	// rMarker matches the hidden comment marker, see commentMarker
+	rMarker = regexp.MustCompile(`<!-- id: ([0-9a-f]+) -->`)
+	// rBitbucketMarker matches the marker as an empty markdown link reference, which
+	// Bitbucket does not render, unlike HTML comments
+	rBitbucketMarker = regexp.MustCompile(`\[//\]: # \(id: ([0-9a-f]+)\)`)
+)
+
+type (
+	// Bitbucket posts the plan to Bitbucket Server and Data Center pull requests
+	Bitbucket struct {
+		client       *restClient
+		webURL       string
+		project      string
+		repo         string
+		commentCache string
+	}
+
+	bitbucketPage struct {
+		IsLastPage    bool `json:"isLastPage"`
+		NextPageStart int  `json:"nextPageStart"`
+	}
+
+	bitbucketPullRequest struct {
+		ID    int    `json:"id"`
+		State string `json:"state"`
+	}
+
+	bitbucketComment struct {
+		ID          int64                  `json:"id"`
+		Version     int                    `json:"version"`
+		Text        string                 `json:"text"`
+		UpdatedDate int64                  `json:"updatedDate"`
+		Properties  map[string]interface{} `json:"properties"`
+	}
+
+	bitbucketActivity struct {
+		Action        string           `json:"action"`
+		CommentAction string           `json:"commentAction"`
+		Comment       bitbucketComment `json:"comment"`
+	}
+)
+
+// NewBitbucket creates the Bitbucket Server client, authenticated with an HTTP access
+// token or with the username and password, which default to the netrc credentials
+func NewBitbucket(config Config, client *http.Client) (*Bitbucket, error) {
+	if config.BaseURL == "" || config.BaseURL == defaultGitHubURL {
+		return nil, fmt.Errorf("You must provide the base URL of the Bitbucket server, e.g. https://bitbucket.example.com/")
+	}
+
+	base := strings.TrimSuffix(config.BaseURL, "/") + "/"
+	if !strings.Contains(base, "/rest/") {
+		base = base + bitbucketAPIPath
+	}
+
+	c, err := newRESTClient(base, client)
+	if err != nil {
+		return nil, err
+	}
+	if config.Token != "" {
+		c.header.Set("Authorization", "Bearer "+config.Token)
+	} else {
+		c.user = url.UserPassword(strings.TrimSpace(config.Username), strings.TrimSpace(config.Password))
+	}
+
+	return &Bitbucket{
+		client:       c,
+		webURL:       base[:strings.Index(base, "/rest/")],
+		project:      config.RepoOwner,
+		repo:         config.RepoName,
+		commentCache: config.CommentCache,
+	}, nil
+}
+
+// PullRequestNumber returns the pull request of the commit, preferring open ones
+func (b *Bitbucket) PullRequestNumber(ctx context.Context, sha string) (int, error) {
+	var res struct {
+		bitbucketPage
+		Values []bitbucketPullRequest `json:"values"`
+	}
+	_, err := b.client.do(ctx, "GET", fmt.Sprintf("projects/%s/repos/%s/commits/%s/pull-requests", b.project, b.repo, url.PathEscape(sha)), nil, &res)
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Values) == 0 {
+		return 0, nil
+	}
+
+	for _, pr := range res.Values {
+		if pr.State == "OPEN" {
+			return pr.ID, nil
+		}
+	}
+
+	return res.Values[0].ID, nil
+}
+
+// FindComment looks up the comment tracked with the key, first by the cached ID and then
+// by scanning the activities newest-first, matching the comment property or marker
+func (b *Bitbucket) FindComment(ctx context.Context, number int, key string) (*Comment, error) {
+	if cached, ok := readCommentCache(b.commentCache)[key]; ok {
+		comment, err := b.getComment(ctx, number, cached.ID)
+		if err == nil {
+			if converted := b.toComment(number, *comment); strings.Contains(converted.Body, commentMarker(key)) {
+				return converted, nil
+			}
+		}
+		logrus.WithFields(logrus.Fields{
+			"id": cached.ID,
+		}).Debug("Cached comment not found, scanning all comments")
+	}
+
+	var found *Comment
+	err := b.eachComment(ctx, number, func(comment bitbucketComment) bool {
+		if converted := b.toComment(number, comment); strings.Contains(converted.Body, commentMarker(key)) {
+			found = converted
+			return false
+		}
+		return true
+	})
+
+	return found, err
+}
+
+// ListComments returns the top level comments of the pull request, newest-first
+func (b *Bitbucket) ListComments(ctx context.Context, number int) ([]*Comment, error) {
+	var comments []*Comment
+	err := b.eachComment(ctx, number, func(comment bitbucketComment) bool {
+		comments = append(comments, b.toComment(number, comment))
+		return true
+	})
+
+	return comments, err
+}
+
+// CreateComment posts a new comment, tracking it with a comment property and a markdown
+// marker since Bitbucket renders HTML comments as text
+func (b *Bitbucket) CreateComment(ctx context.Context, number int, body string) (*Comment, error) {
+	text, properties := bitbucketText(body)
+
+	var comment bitbucketComment
+	_, err := b.client.do(ctx, "POST", b.commentsPath(number), map[string]interface{}{
+		"text":       text,
+		"properties": properties,
+	}, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.toComment(number, comment), nil
+}
+
+// EditComment replaces the text of a comment, which requires its current version
+func (b *Bitbucket) EditComment(ctx context.Context, number int, id int64, body string) (*Comment, error) {
+	current, err := b.getComment(ctx, number, id)
+	if err != nil {
+		return nil, err
+	}
+
+	text, properties := bitbucketText(body)
+
+	var comment bitbucketComment
+	_, err = b.client.do(ctx, "PUT", fmt.Sprintf("%s/%d", b.commentsPath(number), id), map[string]interface{}{
+		"text":       text,
+		"version":    current.Version,
+		"properties": properties,
+	}, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.toComment(number, comment), nil
+}
+
+// DeleteComment deletes a comment at its current version
+func (b *Bitbucket) DeleteComment(ctx context.Context, number int, id int64) error {
+	current, err := b.getComment(ctx, number, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.client.do(ctx, "DELETE", fmt.Sprintf("%s/%d?version=%d", b.commentsPath(number), id, current.Version), nil, nil)
+	return err
+}
+
+func (b *Bitbucket) getComment(ctx context.Context, number int, id int64) (*bitbucketComment, error) {
+	var comment bitbucketComment
+	_, err := b.client.do(ctx, "GET", fmt.Sprintf("%s/%d", b.commentsPath(number), id), nil, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// eachComment calls fn with the comments added to the pull request newest-first, until fn
+// returns false. Comments are only listed as part of the pull request activities.
+func (b *Bitbucket) eachComment(ctx context.Context, number int, fn func(bitbucketComment) bool) error {
+	start := 0
+	for {
+		var res struct {
+			bitbucketPage
+			Values []bitbucketActivity `json:"values"`
+		}
+		_, err := b.client.do(ctx, "GET", fmt.Sprintf("projects/%s/repos/%s/pull-requests/%d/activities?start=%d&limit=%d", b.project, b.repo, number, start, bitbucketPageLimit), nil, &res)
+		if err != nil {
+			return err
+		}
+		for _, activity := range res.Values {
+			if activity.Action != "COMMENTED" || activity.CommentAction != "ADDED" {
+				continue
+			}
+			if !fn(activity.Comment) {
+				return nil
+			}
+		}
+		if res.IsLastPage {
+			return nil
+		}
+		start = res.NextPageStart
+	}
+}
+
+func (b *Bitbucket) commentsPath(number int) string {
+	return fmt.Sprintf("projects/%s/repos/%s/pull-requests/%d/comments", b.project, b.repo, number)
+}
+
+// toComment converts the comment, restoring the marker from the comment property or
+// the markdown marker
+func (b *Bitbucket) toComment(number int, comment bitbucketComment) *Comment {
+	key, _ := comment.Properties[bitbucketPropertyKey].(string)
+	if m := rBitbucketMarker.FindStringSubmatch(comment.Text); m != nil {
+		key = m[1]
+	}
+
+	body := rBitbucketMarker.ReplaceAllString(comment.Text, "")
+	if key != "" {
+		body = fmt.Sprintf("%s\n%s\n", strings.TrimRight(body, "\n"), commentMarker(key))
+	}
+
+	return &Comment{
+		ID:        comment.ID,
+		URL:       fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d/overview?commentId=%d", b.webURL, b.project, b.repo, number, comment.ID),
+		Body:      body,
+		UpdatedAt: time.Unix(0, comment.UpdatedDate*int64(time.Millisecond)),
+	}
+}
+
+// bitbucketText replaces the marker in the body with the markdown marker, returning the
+// key as comment properties as well
+func bitbucketText(body string) (string, map[string]string) {
+	properties := map[string]string{}
+	if m := rMarker.FindStringSubmatch(body); m != nil {
+		properties[bitbucketPropertyKey] = m[1]
+		body = rMarker.ReplaceAllString(body, fmt.Sprintf("[//]: # (id: %s)", m[1]))
+	}
+
+	return body, properties
+}
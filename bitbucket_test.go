@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+// fakeBitbucket is an in-memory stand-in for the parts of the Bitbucket Server API used
+// by the plugin. Activities are derived from the comments, newest-first.
+type fakeBitbucket struct {
+	comments []bitbucketComment
+	pulls    []bitbucketPullRequest
+	limit    int
+	nextID   int64
+	requests []string
+}
+
+func (f *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, _ := r.BasicAuth()
+	if r.Header.Get("Authorization") != "Bearer secret" && (user != "drone" || password != "password") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	path := strings.TrimPrefix(r.URL.Path, "/rest/api/1.0/projects/PRJ/repos/repo/")
+	parts := strings.Split(path, "/")
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case len(parts) == 3 && parts[0] == "commits" && parts[2] == "pull-requests":
+		write(map[string]interface{}{"isLastPage": true, "values": f.pulls})
+	case len(parts) == 3 && parts[0] == "pull-requests" && parts[2] == "activities":
+		f.listActivities(w, r)
+	case len(parts) == 3 && parts[0] == "pull-requests" && parts[2] == "comments" && r.Method == "POST":
+		var in bitbucketComment
+		json.NewDecoder(r.Body).Decode(&in)
+		comment := f.add(in.Text)
+		comment.Properties = in.Properties
+		f.comments[len(f.comments)-1] = comment
+		w.WriteHeader(http.StatusCreated)
+		write(comment)
+	case len(parts) == 4 && parts[0] == "pull-requests" && parts[2] == "comments":
+		id, _ := strconv.ParseInt(parts[3], 10, 64)
+		for i, c := range f.comments {
+			if c.ID != id {
+				continue
+			}
+			switch r.Method {
+			case "PUT":
+				var in bitbucketComment
+				json.NewDecoder(r.Body).Decode(&in)
+				if in.Version != c.Version {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				f.comments[i].Text = in.Text
+				f.comments[i].Properties = in.Properties
+				f.comments[i].Version++
+				write(f.comments[i])
+			case "DELETE":
+				if r.URL.Query().Get("version") != strconv.Itoa(c.Version) {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				f.comments = append(f.comments[:i], f.comments[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				write(c)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// listActivities pages through the activities newest-first, with an approval and a
+// reply between the added comments
+func (f *fakeBitbucket) listActivities(w http.ResponseWriter, r *http.Request) {
+	var activities []bitbucketActivity
+	for i := len(f.comments) - 1; i >= 0; i-- {
+		activities = append(activities,
+			bitbucketActivity{Action: "APPROVED"},
+			bitbucketActivity{Action: "COMMENTED", CommentAction: "REPLIED", Comment: bitbucketComment{ID: 1000 + f.comments[i].ID, Text: f.comments[i].Text}},
+			bitbucketActivity{Action: "COMMENTED", CommentAction: "ADDED", Comment: f.comments[i]},
+		)
+	}
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	limit := f.limit
+	if limit == 0 {
+		limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	}
+	end := start + limit
+	if end > len(activities) {
+		end = len(activities)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"isLastPage":    end == len(activities),
+		"nextPageStart": end,
+		"values":        activities[start:end],
+	})
+}
+
+func (f *fakeBitbucket) add(text string) bitbucketComment {
+	f.nextID++
+	comment := bitbucketComment{ID: f.nextID, Text: text, UpdatedDate: 1500000000000 + f.nextID}
+	f.comments = append(f.comments, comment)
+	return comment
+}
+
+func TestBitbucket(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("bitbucketText", func() {
+		g.It("rewrites the marker as a markdown marker and a property", func() {
+			text, properties := bitbucketText("plan\n" + commentMarker("abc") + "\n")
+			g.Assert(text).Equal("plan\n[//]: # (id: abc)\n")
+			g.Assert(properties).Equal(map[string]string{bitbucketPropertyKey: "abc"})
+		})
+
+		g.It("keeps bodies without a marker", func() {
+			text, properties := bitbucketText("plan")
+			g.Assert(text).Equal("plan")
+			g.Assert(len(properties)).Equal(0)
+		})
+	})
+
+	g.Describe("Bitbucket", func() {
+		var (
+			fake      *fakeBitbucket
+			server    *httptest.Server
+			config    Config
+			bitbucket *Bitbucket
+			ctx       = context.Background()
+			key       = "abc"
+		)
+
+		g.BeforeEach(func() {
+			fake = &fakeBitbucket{}
+			server = httptest.NewServer(fake)
+			config = Config{
+				BaseURL:   server.URL,
+				Token:     "secret",
+				RepoOwner: "PRJ",
+				RepoName:  "repo",
+			}
+
+			var err error
+			bitbucket, err = NewBitbucket(config, http.DefaultClient)
+			g.Assert(err == nil).IsTrue("should create the client")
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("requires a base URL", func() {
+			_, err := NewBitbucket(Config{BaseURL: defaultGitHubURL}, http.DefaultClient)
+			g.Assert(err != nil).IsTrue("should have received error for the GitHub base URL")
+		})
+
+		g.It("authenticates with the username and password without a token", func() {
+			config.Token = ""
+			config.Username = "drone"
+			config.Password = "password"
+			bitbucket, _ = NewBitbucket(config, http.DefaultClient)
+
+			_, err := bitbucket.ListComments(ctx, 7)
+			g.Assert(err == nil).IsTrue("should not error")
+		})
+
+		g.It("prefers the open pull request of a commit", func() {
+			fake.pulls = []bitbucketPullRequest{{ID: 3, State: "MERGED"}, {ID: 4, State: "OPEN"}}
+
+			number, err := bitbucket.PullRequestNumber(ctx, "deadbeef")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(number).Equal(4)
+			g.Assert(fake.requests).Equal([]string{"GET /rest/api/1.0/projects/PRJ/repos/repo/commits/deadbeef/pull-requests"})
+		})
+
+		g.It("finds the newest comment by marker across activity pages", func() {
+			fake.limit = 4
+			fake.add("old plan\n[//]: # (id: abc)")
+			fake.add("new plan\n[//]: # (id: abc)")
+			for i := 0; i < 3; i++ {
+				fake.add("lgtm")
+			}
+
+			comment, err := bitbucket.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(2))
+			g.Assert(comment.Body).Equal("new plan\n" + commentMarker(key) + "\n")
+			g.Assert(comment.URL).Equal(server.URL + "/projects/PRJ/repos/repo/pull-requests/7/overview?commentId=2")
+			g.Assert(len(fake.requests)).Equal(3)
+		})
+
+		g.It("finds comments by their property when the marker was edited out", func() {
+			comment := fake.add("plan")
+			fake.comments[0].Properties = map[string]interface{}{bitbucketPropertyKey: key}
+
+			found, err := bitbucket.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(found.ID).Equal(comment.ID)
+		})
+
+		g.It("ignores replies and other activities", func() {
+			fake.add("lgtm")
+
+			comments, err := bitbucket.ListComments(ctx, 7)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(len(comments)).Equal(1)
+
+			found, err := bitbucket.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(found == nil).IsTrue("should not find a comment")
+		})
+
+		g.It("finds the cached comment without listing the activities", func() {
+			dir, _ := ioutil.TempDir("", "cache")
+			defer os.RemoveAll(dir)
+			config.CommentCache = filepath.Join(dir, "comments.json")
+			bitbucket, _ = NewBitbucket(config, http.DefaultClient)
+
+			fake.add("plan\n[//]: # (id: abc)")
+			fake.add("lgtm")
+			writeCommentCache(config.CommentCache, key, &Comment{ID: 1})
+
+			comment, err := bitbucket.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(1))
+			g.Assert(fake.requests).Equal([]string{"GET /rest/api/1.0/projects/PRJ/repos/repo/pull-requests/7/comments/1"})
+		})
+
+		g.It("scans the activities when the cached comment is gone", func() {
+			dir, _ := ioutil.TempDir("", "cache")
+			defer os.RemoveAll(dir)
+			config.CommentCache = filepath.Join(dir, "comments.json")
+			bitbucket, _ = NewBitbucket(config, http.DefaultClient)
+
+			fake.add("plan\n[//]: # (id: abc)")
+			writeCommentCache(config.CommentCache, key, &Comment{ID: 42})
+
+			comment, err := bitbucket.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(1))
+			g.Assert(len(fake.requests)).Equal(2)
+		})
+
+		g.It("creates, edits and deletes comments at their current version", func() {
+			created, err := bitbucket.CreateComment(ctx, 7, "plan\n"+commentMarker(key)+"\n")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(fake.comments[0].Text).Equal("plan\n[//]: # (id: abc)\n")
+			g.Assert(fake.comments[0].Properties[bitbucketPropertyKey]).Equal(key)
+			g.Assert(created.Body).Equal("plan\n" + commentMarker(key) + "\n")
+
+			// someone else edited the comment in between
+			fake.comments[0].Version = 3
+
+			edited, err := bitbucket.EditComment(ctx, 7, created.ID, "new plan\n"+commentMarker(key)+"\n")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(edited.Body).Equal("new plan\n" + commentMarker(key) + "\n")
+			g.Assert(fake.comments[0].Version).Equal(4)
+
+			err = bitbucket.DeleteComment(ctx, 7, created.ID)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(len(fake.comments)).Equal(0)
+			g.Assert(fake.requests[len(fake.requests)-1]).Equal(fmt.Sprintf("DELETE /rest/api/1.0/projects/PRJ/repos/repo/pull-requests/7/comments/%d?version=4", created.ID))
+		})
+
+		g.It("returns API errors", func() {
+			_, err := bitbucket.EditComment(ctx, 7, 42, "plan")
+			g.Assert(err != nil).IsTrue("should have received error for a missing comment")
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// systemCABundles are the usual locations of the system CA bundle, the same Go checks
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// loadCACerts reads the CA certificates, given as PEM or as paths of PEM files, into a
+// single PEM bundle
+func loadCACerts(certs []string) ([]byte, error) {
+	var bundle bytes.Buffer
+	for _, cert := range certs {
+		cert = strings.TrimSpace(cert)
+		if cert == "" {
+			continue
+		}
+
+		pem := []byte(cert)
+		if !strings.HasPrefix(cert, "-----BEGIN") {
+			b, err := ioutil.ReadFile(cert)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read CA certificate. %s", err)
+			}
+			pem = b
+		}
+
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Failed to parse CA certificate, no PEM certificate found")
+		}
+		bundle.Write(bytes.TrimSpace(pem))
+		bundle.WriteString("\n")
+	}
+
+	return bundle.Bytes(), nil
+}
+
+// writeCABundle writes the system CA bundle and the CA certificates to a temporary
+// file, since SSL_CERT_FILE replaces the system bundle instead of adding to it
+func writeCABundle(certs []byte) (string, error) {
+	var bundle bytes.Buffer
+	if system := systemCABundle(); system != nil {
+		bundle.Write(bytes.TrimSpace(system))
+		bundle.WriteString("\n")
+	} else {
+		logrus.Warn("No system CA bundle found, terraform only trusts the given CA certificates")
+	}
+	bundle.Write(certs)
+
+	f, err := ioutil.TempFile("", "ca-bundle-*.pem")
+	if err != nil {
+		return "", fmt.Errorf("Failed to write CA bundle. %s", err)
+	}
+	_, err = f.Write(bundle.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("Failed to write CA bundle. %s", err)
+	}
+
+	return f.Name(), nil
+}
+
+func systemCABundle() []byte {
+	files := systemCABundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		files = append([]string{file}, files...)
+	}
+
+	for _, file := range files {
+		if b, err := ioutil.ReadFile(file); err == nil {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// caTransport returns a transport trusting the CA certificates in addition to the
+// system ones
+func caTransport(certs []byte) (http.RoundTripper, error) {
+	if len(certs) == 0 {
+		return http.DefaultTransport, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(certs) {
+		return nil, fmt.Errorf("Failed to parse CA certificate, no PEM certificate found")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return transport, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func serverCertPEM(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+func TestCACerts(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("CA certs", func() {
+		var server *httptest.Server
+		var cert string
+
+		g.BeforeEach(func() {
+			server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			cert = serverCertPEM(server)
+		})
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("loads PEM certs and cert files", func() {
+			file, _ := ioutil.TempFile("", "ca")
+			defer os.Remove(file.Name())
+			file.WriteString(cert)
+			file.Close()
+
+			bundle, err := loadCACerts([]string{cert, file.Name(), ""})
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(strings.Count(string(bundle), "-----BEGIN CERTIFICATE-----")).Equal(2)
+		})
+
+		g.It("fails on invalid certs", func() {
+			_, err := loadCACerts([]string{"-----BEGIN CERTIFICATE-----\nnope\n-----END CERTIFICATE-----"})
+			g.Assert(err != nil).IsTrue("should have received error")
+
+			_, err = loadCACerts([]string{"/nonexistent/ca.pem"})
+			g.Assert(err != nil).IsTrue("should have received error")
+		})
+
+		g.It("writes the certs to a bundle", func() {
+			path, err := writeCABundle([]byte(cert))
+			g.Assert(err == nil).IsTrue("should not error")
+			defer os.Remove(path)
+
+			b, _ := ioutil.ReadFile(path)
+			g.Assert(strings.HasSuffix(string(b), cert)).IsTrue("should append the certs")
+		})
+
+		g.It("writes the system bundle first", func() {
+			system, _ := ioutil.TempFile("", "system-*.pem")
+			defer os.Remove(system.Name())
+			system.WriteString("system\n\n")
+			system.Close()
+
+			defer func(files []string, sslCertFile string) {
+				systemCABundles = files
+				os.Setenv("SSL_CERT_FILE", sslCertFile)
+			}(systemCABundles, os.Getenv("SSL_CERT_FILE"))
+			os.Unsetenv("SSL_CERT_FILE")
+			systemCABundles = []string{"/nonexistent/ca.pem", system.Name()}
+
+			path, err := writeCABundle([]byte(cert))
+			g.Assert(err == nil).IsTrue("should not error")
+			defer os.Remove(path)
+
+			b, _ := ioutil.ReadFile(path)
+			g.Assert(string(b)).Equal("system\n" + cert)
+
+			systemCABundles = []string{"/nonexistent/ca.pem"}
+			path, err = writeCABundle([]byte(cert))
+			g.Assert(err == nil).IsTrue("should not error without a system bundle")
+			defer os.Remove(path)
+
+			b, _ = ioutil.ReadFile(path)
+			g.Assert(string(b)).Equal(cert)
+		})
+
+		g.It("trusts the certs in the HTTP client", func() {
+			_, err := (&http.Client{Transport: http.DefaultTransport}).Get(server.URL)
+			g.Assert(err != nil).IsTrue("should not trust the server by default")
+
+			transport, err := caTransport([]byte(cert))
+			g.Assert(err == nil).IsTrue("should not error")
+			res, err := (&http.Client{Transport: transport}).Get(server.URL)
+			g.Assert(err == nil).IsTrue("should trust the server")
+			res.Body.Close()
+		})
+	})
+}
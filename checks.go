@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+	"github.com/robertstettner/drone-terraform-github-commenter/policy"
+)
+
+const (
+	failOnDestroy = "destroy"
+	failOnReplace = "replace"
+)
+
+var failOnActions = []string{failOnDestroy, failOnReplace}
+
+// checkPlan evaluates the fail_on and max_* settings against the plan, returning a
+// message for every violation
+func (p Plugin) checkPlan(plan *parser.Plan) []string {
+	var violations []string
+
+	destroyed := plan.Addresses(parser.ActionDelete)
+	replaced := plan.Addresses(parser.ActionReplace)
+
+	if contains(p.Config.FailOn, failOnDestroy) {
+		for _, address := range destroyed {
+			violations = append(violations, fmt.Sprintf("%s will be destroyed", address))
+		}
+	}
+	if contains(p.Config.FailOn, failOnReplace) {
+		for _, address := range replaced {
+			violations = append(violations, fmt.Sprintf("%s will be replaced", address))
+		}
+	}
+
+	// the limits are only checked when set, as zero is a valid maximum
+	if limit := p.Config.MaxDestroy; limit != nil && len(destroyed) > *limit {
+		violations = append(violations, fmt.Sprintf("%d resource(s) will be destroyed, the maximum is %d", len(destroyed), *limit))
+	}
+	if limit := p.Config.MaxReplace; limit != nil && len(replaced) > *limit {
+		violations = append(violations, fmt.Sprintf("%d resource(s) will be replaced, the maximum is %d", len(replaced), *limit))
+	}
+
+	return violations
+}
+
+// renderViolations formats the violations as a red diff block for the top of the comment
+func renderViolations(violations []string) string {
+	if len(violations) == 0 {
+		return ""
+	}
+
+	var b bytes.Buffer
+	b.WriteString("**:no_entry: This plan fails the build:**\n\n```diff\n")
+	for _, v := range violations {
+		b.WriteString(fmt.Sprintf("- %s\n", v))
+	}
+	b.WriteString("```\n\n")
+
+	return b.String()
+}
+
+// renderPolicyResults formats the policy results as a section of the comment
+func renderPolicyResults(results []policy.Result) string {
+	if len(results) == 0 {
+		return ""
+	}
+
+	var b bytes.Buffer
+	b.WriteString("### Policy checks\n\n")
+	for _, r := range results {
+		icon := ":warning:"
+		if r.Severity == policy.SeverityDeny {
+			icon = ":no_entry:"
+		}
+		// Rego policies are evaluated against the whole plan, not a single resource
+		if r.Address == "" {
+			b.WriteString(fmt.Sprintf("- %s **%s** `%s`: %s\n", icon, r.Severity, r.Rule, r.Message))
+			continue
+		}
+		b.WriteString(fmt.Sprintf("- %s **%s** `%s` on `%s`: %s\n", icon, r.Severity, r.Rule, r.Address, r.Message))
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// checksError fails the build for the violations and the denying policy results
+func checksError(violations []string, results []policy.Result) error {
+	failures := violations
+	for _, r := range results {
+		switch {
+		case r.Severity != policy.SeverityDeny:
+		case r.Address == "":
+			failures = append(failures, fmt.Sprintf("%s: %s", r.Rule, r.Message))
+		default:
+			failures = append(failures, fmt.Sprintf("%s denied by %s", r.Address, r.Rule))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Plan failed %d check(s): %s", len(failures), strings.Join(failures, "; "))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+func TestChecks(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	plan := &parser.Plan{ResourceChanges: []parser.ResourceChange{
+		{Address: "aws_s3_bucket.a", Change: parser.Change{Actions: []string{"delete"}}},
+		{Address: "aws_s3_bucket.b", Change: parser.Change{Actions: []string{"delete"}}},
+		{Address: "aws_instance.c", Change: parser.Change{Actions: []string{"delete", "create"}}},
+		{Address: "aws_instance.d", Change: parser.Change{Actions: []string{"update"}}},
+	}}
+	limit := func(n int) *int {
+		return &n
+	}
+
+	g.Describe("checkPlan", func() {
+		g.It("has no limits by default", func() {
+			p := Plugin{}
+			g.Assert(len(p.checkPlan(plan))).Equal(0)
+		})
+
+		g.It("reports the resources of the fail on actions", func() {
+			p := Plugin{Config: Config{FailOn: []string{failOnDestroy, failOnReplace}}}
+			g.Assert(p.checkPlan(plan)).Equal([]string{
+				"aws_s3_bucket.a will be destroyed",
+				"aws_s3_bucket.b will be destroyed",
+				"aws_instance.c will be replaced",
+			})
+		})
+
+		g.It("reports exceeding the maximums", func() {
+			p := Plugin{Config: Config{MaxDestroy: limit(1), MaxReplace: limit(0)}}
+			g.Assert(p.checkPlan(plan)).Equal([]string{
+				"2 resource(s) will be destroyed, the maximum is 1",
+				"1 resource(s) will be replaced, the maximum is 0",
+			})
+		})
+
+		g.It("allows changes up to the maximums", func() {
+			p := Plugin{Config: Config{MaxDestroy: limit(2), MaxReplace: limit(1)}}
+			g.Assert(len(p.checkPlan(plan))).Equal(0)
+		})
+
+		g.It("allows plans without destroys with a maximum of zero", func() {
+			p := Plugin{Config: Config{MaxDestroy: limit(0), MaxReplace: limit(0)}}
+			g.Assert(len(p.checkPlan(&parser.Plan{ResourceChanges: plan.ResourceChanges[3:]}))).Equal(0)
+		})
+	})
+
+	g.Describe("renderViolations", func() {
+		g.It("renders nothing without violations", func() {
+			g.Assert(renderViolations(nil)).Equal("")
+		})
+
+		g.It("renders the violations as a diff block", func() {
+			g.Assert(renderViolations([]string{"a will be destroyed", "b will be replaced"})).Equal(
+				"**:no_entry: This plan fails the build:**\n\n```diff\n- a will be destroyed\n- b will be replaced\n```\n\n")
+		})
+	})
+}
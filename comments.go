@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	previousCommentsKeep     = "keep"
+	previousCommentsMinimize = "minimize"
+	previousCommentsDelete   = "delete"
+)
+
+var previousCommentsModes = []string{previousCommentsKeep, previousCommentsMinimize, previousCommentsDelete}
+
+const minimizeCommentMutation = `mutation($id: ID!) {
+  minimizeComment(input: {subjectId: $id, classifier: OUTDATED}) {
+    minimizedComment {
+      isMinimized
+    }
+  }
+}`
+
+type (
+	graphQLRequest struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables,omitempty"`
+	}
+
+	graphQLResponse struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	// nodeComment is the subset of an issue comment needed to address it in the GraphQL API
+	nodeComment struct {
+		NodeID string `json:"node_id"`
+	}
+)
+
+// cleanupPreviousComments deletes or minimizes the plugin comments created by previous
+// builds, skipping the comment with the current ID
+func (p Plugin) cleanupPreviousComments(key string, currentID int64) error {
+	mode := p.Config.PreviousComments
+	if mode == "" || mode == previousCommentsKeep {
+		return nil
+	}
+
+	minimizer, ok := p.Config.scm.(commentMinimizer)
+	if mode == previousCommentsMinimize && !ok {
+		return fmt.Errorf("Minimizing previous comments is not supported by %s", p.Config.SCM)
+	}
+
+	comments, err := p.Config.scm.ListComments(p.Config.scmContext, p.Config.IssueNum)
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range filterComments(comments, key) {
+		if comment.ID == currentID {
+			continue
+		}
+
+		switch mode {
+		case previousCommentsDelete:
+			err = p.Config.scm.DeleteComment(p.Config.scmContext, p.Config.IssueNum, comment.ID)
+		case previousCommentsMinimize:
+			err = minimizer.MinimizeComment(p.Config.scmContext, p.Config.IssueNum, comment.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"id":   comment.ID,
+			"mode": mode,
+		}).Info("Cleaned up previous comment in PR")
+	}
+
+	return nil
+}
+
+// MinimizeComment hides a comment as outdated, which is only possible through the GraphQL API
+func (g *GitHub) MinimizeComment(ctx context.Context, number int, id int64) error {
+	return g.minimize(ctx, fmt.Sprintf("repos/%s/%s/issues/comments/%d", g.owner, g.repo, id), id)
+}
+
+// minimize hides the comment at the REST API path, looking up its GraphQL node ID there
+// since go-github does not expose it
+func (g *GitHub) minimize(ctx context.Context, path string, id int64) error {
+	req, err := g.client.NewRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+
+	var comment nodeComment
+	if _, err := g.client.Do(ctx, req, &comment); err != nil {
+		return err
+	}
+	if comment.NodeID == "" {
+		return fmt.Errorf("Failed to minimize comment %d, node ID not found", id)
+	}
+
+	req, err = g.client.NewRequest("POST", graphQLURL(g.client.BaseURL), &graphQLRequest{
+		Query:     minimizeCommentMutation,
+		Variables: map[string]interface{}{"id": comment.NodeID},
+	})
+	if err != nil {
+		return err
+	}
+
+	var res graphQLResponse
+	if _, err := g.client.Do(ctx, req, &res); err != nil {
+		return err
+	}
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("Failed to minimize comment %d. %s", id, res.Errors[0].Message)
+	}
+
+	return nil
+}
+
+// graphQLURL returns the GraphQL endpoint for a REST API base URL, GitHub Enterprise
+// Server serves it from /api/graphql instead of /api/v3/graphql
+func graphQLURL(baseURL *url.URL) string {
+	u := *baseURL
+	if strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "graphql"
+	} else {
+		u.Path = u.Path + "graphql"
+	}
+
+	return u.String()
+}
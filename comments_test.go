@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestPreviousComments(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("cleanupPreviousComments", func() {
+		var gh *fakeGitHub
+		var server *httptest.Server
+		var plugin Plugin
+		var key string
+
+		setup := func(baseURL string) {
+			plugin = Plugin{
+				Config: Config{
+					BaseURL:   baseURL,
+					Token:     "secret",
+					SCM:       scmGitHub,
+					RepoOwner: "owner",
+					RepoName:  "repo",
+					Title:     "Terraform Plan Output",
+					IssueNum:  7,
+				},
+			}
+			plugin.Config.scmContext = context.Background()
+			plugin.Config.scm, _ = newSCM(plugin.Config)
+			key = generateKey(plugin.Config)
+		}
+
+		g.BeforeEach(func() {
+			gh = &fakeGitHub{login: "drone"}
+			server = httptest.NewServer(gh)
+			setup(server.URL + "/")
+
+			gh.add("old plan\n"+commentMarker(key), "drone")
+			gh.add("LGTM", "reviewer")
+			gh.add("other stack\n"+commentMarker("other"), "drone")
+			gh.add("older plan\n"+commentMarker(key), "drone")
+			gh.add("new plan\n"+commentMarker(key), "drone")
+			gh.requests = nil
+		})
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("keeps the previous comments by default", func() {
+			g.Assert(plugin.cleanupPreviousComments(key, 5) == nil).IsTrue("should not error")
+			g.Assert(len(gh.requests)).Equal(0)
+			g.Assert(len(gh.comments)).Equal(5)
+		})
+
+		g.It("deletes the previous comments with the key", func() {
+			plugin.Config.PreviousComments = previousCommentsDelete
+			g.Assert(plugin.cleanupPreviousComments(key, 5) == nil).IsTrue("should not error")
+
+			var bodies []string
+			for _, comment := range gh.comments {
+				bodies = append(bodies, strings.SplitN(comment.GetBody(), "\n", 2)[0])
+			}
+			g.Assert(bodies).Equal([]string{"LGTM", "other stack", "new plan"})
+		})
+
+		g.It("minimizes the previous comments by node ID", func() {
+			plugin.Config.PreviousComments = previousCommentsMinimize
+			g.Assert(plugin.cleanupPreviousComments(key, 5) == nil).IsTrue("should not error")
+
+			g.Assert(gh.minimized).Equal([]string{"IC_1", "IC_4"})
+			g.Assert(gh.requests[1:]).Equal([]string{
+				"GET /repos/owner/repo/issues/comments/1",
+				"POST /graphql",
+				"GET /repos/owner/repo/issues/comments/4",
+				"POST /graphql",
+			})
+			g.Assert(len(gh.comments)).Equal(5)
+		})
+
+		g.It("minimizes through the GraphQL API of GitHub Enterprise Server", func() {
+			server.Close()
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/api/graphql":
+					r.URL.Path = "/graphql"
+				case strings.HasPrefix(r.URL.Path, "/api/v3/"):
+					r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api/v3")
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				gh.ServeHTTP(w, r)
+			}))
+			setup(server.URL + "/api/v3/")
+
+			plugin.Config.PreviousComments = previousCommentsMinimize
+			g.Assert(plugin.cleanupPreviousComments(key, 5) == nil).IsTrue("should not error")
+			g.Assert(gh.minimized).Equal([]string{"IC_1", "IC_4"})
+		})
+	})
+
+	g.Describe("graphQLURL", func() {
+		g.It("derives the GraphQL endpoint from the REST base URL", func() {
+			for base, expected := range map[string]string{
+				"https://api.github.com/":            "https://api.github.com/graphql",
+				"https://github.example.com/api/v3/": "https://github.example.com/api/graphql",
+				"http://localhost:8080/":             "http://localhost:8080/graphql",
+				"https://example.com/github/api/v3/": "https://example.com/github/api/graphql",
+			} {
+				u, _ := url.Parse(base)
+				g.Assert(graphQLURL(u)).Equal(expected)
+			}
+		})
+	})
+}
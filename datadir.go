@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// preservedDataDirs are the provider directories of the data dir, kept when cleaning it
+// so stacks sharing the data dir do not download the providers again. Terraform 0.12
+// keeps them in plugins and later versions in providers.
+var preservedDataDirs = []string{"plugins", "providers"}
+
+// cleanDataDir resets the data dir before init, keeping the providers and the plugin
+// cache dir when it is inside the data dir
+func (p Plugin) cleanDataDir() error {
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	dir, err := dataDirPath(workspace, p.Config.TerraformRootDir, p.Config.TerraformDataDir)
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, name := range preservedDataDirs {
+		keep[filepath.Join(dir, name)] = true
+	}
+	if p.Config.PluginCacheDir != "" {
+		cache, err := filepath.Abs(p.Config.PluginCacheDir)
+		if err != nil {
+			return err
+		}
+		keep[cache] = true
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"dir": dir,
+	}).Debug("Cleaning the data dir")
+
+	return removeAllExcept(dir, keep)
+}
+
+// dataDirPath resolves the data dir like terraform does, relative to the root dir, and
+// makes sure it is inside the workspace
+func dataDirPath(workspace, rootDir, dataDir string) (string, error) {
+	if strings.TrimSpace(dataDir) == "" {
+		return "", fmt.Errorf("Data dir is empty")
+	}
+
+	dir := dataDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspace, rootDir, dir)
+	}
+	dir = filepath.Clean(dir)
+
+	rel, err := filepath.Rel(filepath.Clean(workspace), dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Data dir %s is not inside the workspace %s", dataDir, workspace)
+	}
+
+	return dir, nil
+}
+
+// removeAllExcept removes the directory, but keeps the paths to keep and their parents.
+// Symlinks are removed, not followed.
+func removeAllExcept(dir string, keep map[string]bool) error {
+	if keep[dir] {
+		return nil
+	}
+
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() || !containsKept(dir, keep) {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("Failed to clean the data dir. %s", err)
+		}
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to clean the data dir. %s", err)
+	}
+	for _, entry := range entries {
+		if err := removeAllExcept(filepath.Join(dir, entry.Name()), keep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsKept(dir string, keep map[string]bool) bool {
+	prefix := dir + string(filepath.Separator)
+	for path := range keep {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestDataDir(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("dataDirPath", func() {
+		g.It("resolves the data dir relative to the root dir", func() {
+			dir, err := dataDirPath("/drone/src", "stacks/prod", ".terraform")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(dir).Equal("/drone/src/stacks/prod/.terraform")
+
+			dir, err = dataDirPath("/drone/src", "", "/drone/src/.terraform-prod")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(dir).Equal("/drone/src/.terraform-prod")
+		})
+
+		g.It("rejects data dirs outside the workspace", func() {
+			for _, dataDir := range []string{"/", "", ".", "..", "../.terraform", "/drone/src-other", "/tmp/.terraform"} {
+				_, err := dataDirPath("/drone/src", "", dataDir)
+				g.Assert(err != nil).IsTrue("should reject " + dataDir)
+			}
+
+			_, err := dataDirPath("/drone/src", "stacks", "../../.terraform")
+			g.Assert(err != nil).IsTrue("should reject data dirs escaping the root dir")
+		})
+	})
+
+	g.Describe("cleanDataDir", func() {
+		var workspace, wd string
+
+		g.BeforeEach(func() {
+			wd, _ = os.Getwd()
+			workspace, _ = ioutil.TempDir("", "workspace")
+			workspace, _ = filepath.EvalSymlinks(workspace)
+			os.Chdir(workspace)
+
+			for _, file := range []string{
+				".terraform/terraform.tfstate",
+				".terraform/modules/modules.json",
+				".terraform/providers/registry.terraform.io/hashicorp/aws/provider",
+				".terraform/plugin-cache/provider",
+				"main.tf",
+			} {
+				os.MkdirAll(filepath.Dir(file), 0755)
+				ioutil.WriteFile(file, []byte("x"), 0644)
+			}
+		})
+		g.AfterEach(func() {
+			os.Chdir(wd)
+			os.RemoveAll(workspace)
+		})
+
+		exists := func(path string) bool {
+			_, err := os.Stat(filepath.Join(workspace, path))
+			return err == nil
+		}
+
+		g.It("removes the data dir except for the providers and the plugin cache", func() {
+			p := Plugin{Config: Config{TerraformDataDir: ".terraform", PluginCacheDir: ".terraform/plugin-cache"}}
+			g.Assert(p.cleanDataDir() == nil).IsTrue("should not error")
+
+			g.Assert(exists(".terraform/terraform.tfstate")).IsFalse()
+			g.Assert(exists(".terraform/modules")).IsFalse()
+			g.Assert(exists(".terraform/providers/registry.terraform.io/hashicorp/aws/provider")).IsTrue()
+			g.Assert(exists(".terraform/plugin-cache/provider")).IsTrue()
+			g.Assert(exists("main.tf")).IsTrue()
+		})
+
+		g.It("ignores missing data dirs", func() {
+			p := Plugin{Config: Config{TerraformDataDir: ".terraform-missing"}}
+			g.Assert(p.cleanDataDir() == nil).IsTrue("should not error")
+		})
+
+		g.It("refuses to clean the workspace", func() {
+			p := Plugin{Config: Config{TerraformDataDir: "."}}
+			g.Assert(p.cleanDataDir() != nil).IsTrue("should have received error")
+			g.Assert(exists("main.tf")).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	// environment builds the environment of the commands run by the plugin, instead of
+	// changing the environment of the plugin itself. Only terraform gets the terraform
+	// variables, like the assumed role credentials.
+	environment struct {
+		base      []string
+		terraform map[string]string
+	}
+)
+
+// pluginSecretEnvs are the secrets read by the plugin outside of its settings, which the
+// commands do not get. The SCM tokens are added from scmTokenEnvs.
+var pluginSecretEnvs = []string{
+	"GITHUB_USERNAME",
+	"GITHUB_PASSWORD",
+	"TFC_TOKEN",
+	"TFE_TOKEN",
+	"SSH_KEY",
+}
+
+// newEnvironment starts from the environment of the plugin, without the plugin settings,
+// the netrc credentials and the other secrets of the plugin
+func newEnvironment(environ []string) *environment {
+	secrets := map[string]bool{}
+	for _, key := range pluginSecretEnvs {
+		secrets[key] = true
+	}
+	for _, keys := range scmTokenEnvs {
+		for _, key := range keys {
+			secrets[key] = true
+		}
+	}
+
+	e := &environment{terraform: map[string]string{}}
+	for _, kv := range environ {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(key, "PLUGIN_") || strings.HasPrefix(key, "DRONE_NETRC_") || secrets[key] {
+			continue
+		}
+		e.base = append(e.base, kv)
+	}
+
+	return e
+}
+
+// buildEnvironment adds the env_file and env settings to the environment of terraform,
+// with the env settings taking precedence
+func (p Plugin) buildEnvironment() (*environment, error) {
+	e := newEnvironment(os.Environ())
+
+	if p.Config.EnvFile != "" {
+		vars, err := readEnvFile(p.Config.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+		e.SetAll(vars)
+	}
+	e.SetAll(p.Config.Env)
+
+	if p.Config.TerraformDataDir != "" {
+		e.Set("TF_DATA_DIR", p.Config.TerraformDataDir)
+	}
+	if p.Config.PluginCacheDir != "" {
+		// terraform runs in the root dir, so relative paths are resolved from the workspace
+		dir, err := filepath.Abs(p.Config.PluginCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		e.Set("TF_PLUGIN_CACHE_DIR", dir)
+	}
+
+	return e, nil
+}
+
+// Set adds a variable to the environment of terraform
+func (e *environment) Set(key, value string) {
+	e.terraform[key] = value
+}
+
+// SetAll adds the variables to the environment of terraform
+func (e *environment) SetAll(vars map[string]string) {
+	for k, v := range vars {
+		e.Set(k, v)
+	}
+}
+
+// Environ returns the environment of the command, in the format of exec.Cmd.Env
+func (e *environment) Environ(command string) []string {
+	if filepath.Base(command) != "terraform" {
+		return append([]string{}, e.base...)
+	}
+
+	keys := make([]string, 0, len(e.terraform))
+	for k := range e.terraform {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// later values win, so the terraform variables override the base environment
+	env := append([]string{}, e.base...)
+	for _, k := range keys {
+		env = append(env, k+"="+e.terraform[k])
+	}
+
+	return env
+}
+
+// readEnvFile reads KEY=VALUE lines, skipping blank lines and comments. Values may be
+// quoted and lines may start with export.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read env file. %s", err)
+	}
+	defer f.Close()
+
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		s = strings.TrimPrefix(s, "export ")
+
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Failed to read env file. Line %d is not KEY=VALUE", line)
+		}
+
+		key := strings.TrimSpace(s[:i])
+		value := strings.TrimSpace(s[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read env file. %s", err)
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestEnvironment(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("environment", func() {
+		g.It("only passes the terraform variables to terraform", func() {
+			e := newEnvironment([]string{"PATH=/bin", "PLUGIN_API_KEY=secret", "TF_LOG=INFO"})
+			e.Set("AWS_SESSION_TOKEN", "token")
+			e.Set("TF_LOG", "DEBUG")
+
+			g.Assert(e.Environ("/usr/bin/terraform")).Equal([]string{"PATH=/bin", "TF_LOG=INFO", "AWS_SESSION_TOKEN=token", "TF_LOG=DEBUG"})
+			g.Assert(e.Environ("/usr/bin/git")).Equal([]string{"PATH=/bin", "TF_LOG=INFO"})
+		})
+
+		g.It("does not pass the secrets of the plugin to the commands", func() {
+			e := newEnvironment([]string{
+				"PATH=/bin",
+				"PLUGIN_API_KEY=secret",
+				"GITHUB_TOKEN=secret",
+				"GITHUB_RELEASE_API_KEY=secret",
+				"GITHUB_PASSWORD=secret",
+				"GITLAB_TOKEN=secret",
+				"GITEA_TOKEN=secret",
+				"BITBUCKET_TOKEN=secret",
+				"DRONE_NETRC_MACHINE=github.com",
+				"DRONE_NETRC_PASSWORD=secret",
+				"TFC_TOKEN=secret",
+				"TFE_TOKEN=secret",
+				"SSH_KEY=secret",
+				"DRONE_COMMIT_SHA=abc123",
+				"AWS_WEB_IDENTITY_TOKEN_FILE=/token",
+			})
+			e.Set("TFE_TOKEN", "from env setting")
+
+			g.Assert(e.Environ("git")).Equal([]string{"PATH=/bin", "DRONE_COMMIT_SHA=abc123", "AWS_WEB_IDENTITY_TOKEN_FILE=/token"})
+			g.Assert(e.Environ("terraform")).Equal([]string{"PATH=/bin", "DRONE_COMMIT_SHA=abc123", "AWS_WEB_IDENTITY_TOKEN_FILE=/token", "TFE_TOKEN=from env setting"})
+		})
+
+		g.It("builds the environment from the env file and settings", func() {
+			file, _ := ioutil.TempFile("", "env")
+			defer os.Remove(file.Name())
+			file.WriteString("# provider settings\nexport AWS_REGION=eu-west-1\nTF_VAR_name=\"from file\"\n\nTF_VAR_size='small'\n")
+			file.Close()
+
+			p := Plugin{Config: Config{
+				EnvFile:          file.Name(),
+				Env:              map[string]string{"TF_VAR_size": "large"},
+				TerraformDataDir: ".terraform-prod",
+			}}
+			e, err := p.buildEnvironment()
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(e.terraform).Equal(map[string]string{
+				"AWS_REGION":  "eu-west-1",
+				"TF_VAR_name": "from file",
+				"TF_VAR_size": "large",
+				"TF_DATA_DIR": ".terraform-prod",
+			})
+		})
+
+		g.It("fails on invalid env files", func() {
+			file, _ := ioutil.TempFile("", "env")
+			defer os.Remove(file.Name())
+			file.WriteString("TF_VAR_name\n")
+			file.Close()
+
+			_, err := readEnvFile(file.Name())
+			g.Assert(err != nil).IsTrue("should have received error")
+		})
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const giteaPageLimit = 50
+
+type (
+	// Gitea posts the plan to Gitea and Forgejo pull requests
+	Gitea struct {
+		client       *restClient
+		owner        string
+		repo         string
+		commentCache string
+	}
+
+	giteaPullRequest struct {
+		Number int `json:"number"`
+		Head   struct {
+			Sha string `json:"sha"`
+		} `json:"head"`
+	}
+
+	giteaComment struct {
+		ID        int64     `json:"id"`
+		Body      string    `json:"body"`
+		HTMLURL   string    `json:"html_url"`
+		UpdatedAt time.Time `json:"updated_at"`
+		User      struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+
+	giteaUser struct {
+		Login string `json:"login"`
+	}
+)
+
+// NewGitea creates the Gitea client, authenticated with the token or the username and password
+func NewGitea(config Config, client *http.Client) (*Gitea, error) {
+	if config.BaseURL == "" || config.BaseURL == defaultGitHubURL {
+		return nil, fmt.Errorf("You must provide the base URL of the Gitea API, e.g. https://gitea.example.com/api/v1/")
+	}
+
+	c, err := newRESTClient(config.BaseURL, client)
+	if err != nil {
+		return nil, err
+	}
+	if config.Token != "" {
+		c.header.Set("Authorization", "token "+config.Token)
+	} else {
+		c.user = url.UserPassword(strings.TrimSpace(config.Username), strings.TrimSpace(config.Password))
+	}
+
+	return &Gitea{
+		client:       c,
+		owner:        config.RepoOwner,
+		repo:         config.RepoName,
+		commentCache: config.CommentCache,
+	}, nil
+}
+
+// PullRequestNumber returns the pull request of the commit, falling back to searching
+// the open pull requests by head commit on Gitea versions without the commit lookup
+func (g *Gitea) PullRequestNumber(ctx context.Context, sha string) (int, error) {
+	var pr giteaPullRequest
+	resp, err := g.client.do(ctx, "GET", fmt.Sprintf("repos/%s/%s/commits/%s/pull", g.owner, g.repo, url.PathEscape(sha)), nil, &pr)
+	if err == nil {
+		return pr.Number, nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return 0, err
+	}
+
+	for page := 1; ; page++ {
+		var prs []giteaPullRequest
+		_, err := g.client.do(ctx, "GET", fmt.Sprintf("repos/%s/%s/pulls?state=open&page=%d&limit=%d", g.owner, g.repo, page, giteaPageLimit), nil, &prs)
+		if err != nil {
+			return 0, err
+		}
+		for _, pr := range prs {
+			if pr.Head.Sha == sha {
+				return pr.Number, nil
+			}
+		}
+		if len(prs) < giteaPageLimit {
+			return 0, nil
+		}
+	}
+}
+
+// FindComment looks up the plugin comment, first by the cached ID and then by scanning
+// the comments of the authenticated user newest-first
+func (g *Gitea) FindComment(ctx context.Context, number int, key string) (*Comment, error) {
+	if cached, ok := readCommentCache(g.commentCache)[key]; ok {
+		var comment giteaComment
+		_, err := g.client.do(ctx, "GET", fmt.Sprintf("repos/%s/%s/issues/comments/%d", g.owner, g.repo, cached.ID), nil, &comment)
+		if err == nil && strings.Contains(comment.Body, commentMarker(key)) {
+			return giteaToComment(comment), nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"id": cached.ID,
+		}).Debug("Cached comment not found, scanning all comments")
+	}
+
+	var user giteaUser
+	if _, err := g.client.do(ctx, "GET", "user", nil, &user); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Debug("Failed to get the authenticated user, not filtering comments by author")
+	}
+
+	comments, err := g.listComments(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(comments) - 1; i >= 0; i-- {
+		comment := comments[i]
+		if user.Login != "" && comment.User.Login != user.Login {
+			continue
+		}
+		if strings.Contains(comment.Body, commentMarker(key)) {
+			return giteaToComment(comment), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ListComments returns all comments of the pull request
+func (g *Gitea) ListComments(ctx context.Context, number int) ([]*Comment, error) {
+	comments, err := g.listComments(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	var converted []*Comment
+	for _, comment := range comments {
+		converted = append(converted, giteaToComment(comment))
+	}
+
+	return converted, nil
+}
+
+// CreateComment posts a new comment to the pull request
+func (g *Gitea) CreateComment(ctx context.Context, number int, body string) (*Comment, error) {
+	var comment giteaComment
+	_, err := g.client.do(ctx, "POST", fmt.Sprintf("repos/%s/%s/issues/%d/comments", g.owner, g.repo, number), map[string]string{"body": body}, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return giteaToComment(comment), nil
+}
+
+// EditComment replaces the body of a pull request comment
+func (g *Gitea) EditComment(ctx context.Context, number int, id int64, body string) (*Comment, error) {
+	var comment giteaComment
+	_, err := g.client.do(ctx, "PATCH", fmt.Sprintf("repos/%s/%s/issues/comments/%d", g.owner, g.repo, id), map[string]string{"body": body}, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return giteaToComment(comment), nil
+}
+
+// DeleteComment deletes a pull request comment
+func (g *Gitea) DeleteComment(ctx context.Context, number int, id int64) error {
+	_, err := g.client.do(ctx, "DELETE", fmt.Sprintf("repos/%s/%s/issues/comments/%d", g.owner, g.repo, id), nil, nil)
+	return err
+}
+
+// listComments returns all pages of the comments, oldest-first
+func (g *Gitea) listComments(ctx context.Context, number int) ([]giteaComment, error) {
+	var all []giteaComment
+	for page := 1; ; page++ {
+		var comments []giteaComment
+		_, err := g.client.do(ctx, "GET", fmt.Sprintf("repos/%s/%s/issues/%d/comments?page=%d&limit=%d", g.owner, g.repo, number, page, giteaPageLimit), nil, &comments)
+		if err != nil {
+			return nil, err
+		}
+		// older versions ignore the pagination and always return every comment
+		if page > 1 && len(comments) > 0 && comments[0].ID == all[0].ID {
+			return all, nil
+		}
+		all = append(all, comments...)
+		if len(comments) < giteaPageLimit {
+			return all, nil
+		}
+	}
+}
+
+func giteaToComment(comment giteaComment) *Comment {
+	return &Comment{
+		ID:        comment.ID,
+		URL:       comment.HTMLURL,
+		Body:      comment.Body,
+		UpdatedAt: comment.UpdatedAt,
+	}
+}
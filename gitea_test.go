@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+// fakeGitea is an in-memory stand-in for the parts of the Gitea API used by the plugin
+type fakeGitea struct {
+	comments   []giteaComment
+	pulls      []giteaPullRequest
+	commitPull bool
+	nextID     int64
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+	parts := strings.Split(path, "/")
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case path == "user":
+		write(giteaUser{Login: "drone"})
+	case len(parts) == 6 && parts[3] == "commits" && parts[5] == "pull":
+		if !f.commitPull {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for _, pr := range f.pulls {
+			if pr.Head.Sha == parts[4] {
+				write(pr)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case len(parts) == 4 && parts[3] == "pulls":
+		write(f.pulls)
+	case len(parts) == 6 && parts[3] == "issues" && parts[5] == "comments" && r.Method == "GET":
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		start := (page - 1) * limit
+		if start > len(f.comments) {
+			start = len(f.comments)
+		}
+		end := start + limit
+		if end > len(f.comments) {
+			end = len(f.comments)
+		}
+		write(f.comments[start:end])
+	case len(parts) == 6 && parts[3] == "issues" && parts[5] == "comments" && r.Method == "POST":
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		f.nextID++
+		c := giteaComment{ID: f.nextID, Body: in["body"], HTMLURL: fmt.Sprintf("https://gitea.local/o/r/pulls/%s#issuecomment-%d", parts[4], f.nextID)}
+		c.User.Login = "drone"
+		f.comments = append(f.comments, c)
+		w.WriteHeader(http.StatusCreated)
+		write(c)
+	case len(parts) == 6 && parts[3] == "issues" && parts[4] == "comments":
+		id, _ := strconv.ParseInt(parts[5], 10, 64)
+		for i, c := range f.comments {
+			if c.ID != id {
+				continue
+			}
+			switch r.Method {
+			case "PATCH":
+				var in map[string]string
+				json.NewDecoder(r.Body).Decode(&in)
+				f.comments[i].Body = in["body"]
+				write(f.comments[i])
+			case "DELETE":
+				f.comments = append(f.comments[:i], f.comments[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				write(c)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGitea) addComment(login, body string) {
+	f.nextID++
+	c := giteaComment{ID: f.nextID, Body: body}
+	c.User.Login = login
+	f.comments = append(f.comments, c)
+}
+
+func TestGitea(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Gitea", func() {
+		var (
+			fake   *fakeGitea
+			server *httptest.Server
+			gitea  *Gitea
+			ctx    = context.Background()
+			key    = "abc"
+		)
+
+		g.BeforeEach(func() {
+			fake = &fakeGitea{}
+			server = httptest.NewServer(fake)
+
+			var err error
+			gitea, err = NewGitea(Config{
+				BaseURL:   server.URL + "/api/v1",
+				Token:     "secret",
+				RepoOwner: "o",
+				RepoName:  "r",
+			}, http.DefaultClient)
+			g.Assert(err == nil).IsTrue("should create the client")
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("requires a base URL", func() {
+			_, err := NewGitea(Config{BaseURL: defaultGitHubURL}, http.DefaultClient)
+			g.Assert(err != nil).IsTrue("should have received error for the GitHub base URL")
+		})
+
+		g.It("finds the pull request of a commit", func() {
+			fake.commitPull = true
+			fake.pulls = []giteaPullRequest{{Number: 7}}
+			fake.pulls[0].Head.Sha = "deadbeef"
+
+			number, err := gitea.PullRequestNumber(ctx, "deadbeef")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(number).Equal(7)
+		})
+
+		g.It("falls back to the open pull requests on older versions", func() {
+			fake.pulls = []giteaPullRequest{{Number: 3}, {Number: 4}}
+			fake.pulls[0].Head.Sha = "cafe"
+			fake.pulls[1].Head.Sha = "deadbeef"
+
+			number, err := gitea.PullRequestNumber(ctx, "deadbeef")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(number).Equal(4)
+
+			number, err = gitea.PullRequestNumber(ctx, "unknown")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(number).Equal(0)
+		})
+
+		g.It("finds the newest plugin comment of the authenticated user", func() {
+			for i := 0; i < 60; i++ {
+				fake.addComment("someone", "lgtm")
+			}
+			fake.addComment("drone", "old plan\n"+commentMarker(key))
+			fake.addComment("someone", "quoting "+commentMarker(key))
+			fake.addComment("drone", "new plan\n"+commentMarker(key))
+
+			comment, err := gitea.FindComment(ctx, 1, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(63))
+		})
+
+		g.It("returns nil when there is no plugin comment", func() {
+			fake.addComment("drone", "lgtm")
+
+			comment, err := gitea.FindComment(ctx, 1, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment == nil).IsTrue("should not find a comment")
+		})
+
+		g.It("creates, edits and deletes comments", func() {
+			created, err := gitea.CreateComment(ctx, 1, "plan")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(created.URL).Equal("https://gitea.local/o/r/pulls/1#issuecomment-1")
+
+			edited, err := gitea.EditComment(ctx, 1, created.ID, "new plan")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(edited.Body).Equal("new plan")
+
+			err = gitea.DeleteComment(ctx, 1, created.ID)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(len(fake.comments)).Equal(0)
+		})
+
+		g.It("returns API errors", func() {
+			_, err := gitea.EditComment(ctx, 1, 42, "plan")
+			g.Assert(err != nil).IsTrue("should have received error for a missing comment")
+		})
+	})
+}
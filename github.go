@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const defaultGitHubURL = "https://api.github.com/"
+
+type (
+	// GitHub posts the plan to GitHub and GitHub Enterprise Server pull requests
+	GitHub struct {
+		client       *github.Client
+		owner        string
+		repo         string
+		commentCache string
+	}
+)
+
+// NewGitHub creates the GitHub client, authenticated with the token or the username and password
+func NewGitHub(config Config, client *http.Client) (*GitHub, error) {
+	baseURL, err := parseBaseURL(config.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Token != "" {
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.Token})
+		client = oauth2.NewClient(ctx, ts)
+	} else {
+		tp := github.BasicAuthTransport{
+			Username:  strings.TrimSpace(config.Username),
+			Password:  strings.TrimSpace(config.Password),
+			Transport: client.Transport,
+		}
+		client = tp.Client()
+	}
+
+	gh := &GitHub{
+		client:       github.NewClient(client),
+		owner:        config.RepoOwner,
+		repo:         config.RepoName,
+		commentCache: config.CommentCache,
+	}
+	gh.client.BaseURL = baseURL
+
+	return gh, nil
+}
+
+// PullRequestNumber searches the pull request containing the commit
+func (g *GitHub) PullRequestNumber(ctx context.Context, sha string) (int, error) {
+	res, _, err := g.client.Search.Issues(ctx, fmt.Sprintf("%s repo:%s/%s", sha, g.owner, g.repo), nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Issues) == 0 {
+		return 0, nil
+	}
+
+	return *res.Issues[0].Number, nil
+}
+
+// ListComments returns all pages of the issue comments
+func (g *GitHub) ListComments(ctx context.Context, number int) ([]*Comment, error) {
+	opts := &github.IssueListCommentsOptions{}
+
+	// get all pages of results
+	var allComments []*Comment
+	for {
+		comments, resp, err := g.client.Issues.ListComments(ctx, g.owner, g.repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			allComments = append(allComments, githubComment(comment))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return allComments, nil
+}
+
+// CreateComment posts a new issue comment
+func (g *GitHub) CreateComment(ctx context.Context, number int, body string) (*Comment, error) {
+	comment, _, err := g.client.Issues.CreateComment(ctx, g.owner, g.repo, number, &github.IssueComment{
+		Body: &body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return githubComment(comment), nil
+}
+
+// EditComment replaces the body of an issue comment
+func (g *GitHub) EditComment(ctx context.Context, number int, id int64, body string) (*Comment, error) {
+	comment, _, err := g.client.Issues.EditComment(ctx, g.owner, g.repo, id, &github.IssueComment{
+		Body: &body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return githubComment(comment), nil
+}
+
+// DeleteComment deletes an issue comment
+func (g *GitHub) DeleteComment(ctx context.Context, number int, id int64) error {
+	_, err := g.client.Issues.DeleteComment(ctx, g.owner, g.repo, id)
+	return err
+}
+
+func githubComment(comment *github.IssueComment) *Comment {
+	return &Comment{
+		ID:        comment.GetID(),
+		URL:       comment.GetHTMLURL(),
+		Body:      comment.GetBody(),
+		UpdatedAt: comment.GetUpdatedAt(),
+	}
+}
+
+func parseBaseURL(base string) (*url.URL, error) {
+	if !strings.HasSuffix(base, "/") {
+		base = base + "/"
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse base URL. %s", err)
+	}
+
+	return baseURL, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultGitLabURL = "https://gitlab.com/api/v4/"
+
+type (
+	// GitLab posts the plan as notes to GitLab merge requests
+	GitLab struct {
+		client       *restClient
+		project      string
+		commentCache string
+		webURLs      map[int]string
+	}
+
+	gitlabMergeRequest struct {
+		IID    int    `json:"iid"`
+		State  string `json:"state"`
+		WebURL string `json:"web_url"`
+	}
+
+	gitlabNote struct {
+		ID        int64     `json:"id"`
+		Body      string    `json:"body"`
+		System    bool      `json:"system"`
+		UpdatedAt time.Time `json:"updated_at"`
+		Author    struct {
+			Username string `json:"username"`
+		} `json:"author"`
+	}
+
+	gitlabUser struct {
+		Username string `json:"username"`
+	}
+)
+
+// NewGitLab creates the GitLab client, authenticated with the token as private token or
+// with the password as OAuth token, which is what Drone provides in the netrc password.
+// The base URL defaults to gitlab.com, and is required for repositories on other hosts.
+func NewGitLab(config Config, client *http.Client) (*GitLab, error) {
+	base := config.BaseURL
+	if base == "" || base == defaultGitHubURL {
+		if host := remoteHost(config.RemoteURL); host != "" && host != "gitlab.com" {
+			return nil, fmt.Errorf("You must provide the base URL of the GitLab API for %s, e.g. https://%s/api/v4/", host, host)
+		}
+		base = defaultGitLabURL
+	}
+
+	c, err := newRESTClient(base, client)
+	if err != nil {
+		return nil, err
+	}
+	if config.Token != "" {
+		c.header.Set("PRIVATE-TOKEN", config.Token)
+	} else {
+		c.header.Set("Authorization", "Bearer "+strings.TrimSpace(config.Password))
+	}
+
+	return &GitLab{
+		client:       c,
+		project:      url.PathEscape(config.RepoOwner + "/" + config.RepoName),
+		commentCache: config.CommentCache,
+		webURLs:      map[int]string{},
+	}, nil
+}
+
+// PullRequestNumber returns the IID of the merge request of the commit, preferring open ones
+func (g *GitLab) PullRequestNumber(ctx context.Context, sha string) (int, error) {
+	var mrs []gitlabMergeRequest
+	_, err := g.client.do(ctx, "GET", fmt.Sprintf("projects/%s/repository/commits/%s/merge_requests", g.project, url.PathEscape(sha)), nil, &mrs)
+	if err != nil {
+		return 0, err
+	}
+	if len(mrs) == 0 {
+		return 0, nil
+	}
+
+	for _, mr := range mrs {
+		if mr.State == "opened" {
+			return mr.IID, nil
+		}
+	}
+
+	return mrs[0].IID, nil
+}
+
+// FindComment looks up the plugin note, first by the cached ID and then by scanning
+// the notes of the authenticated user newest-first, stopping at the first match
+func (g *GitLab) FindComment(ctx context.Context, number int, key string) (*Comment, error) {
+	if cached, ok := readCommentCache(g.commentCache)[key]; ok {
+		var note gitlabNote
+		_, err := g.client.do(ctx, "GET", fmt.Sprintf("projects/%s/merge_requests/%d/notes/%d", g.project, number, cached.ID), nil, &note)
+		if err == nil && strings.Contains(note.Body, commentMarker(key)) {
+			return g.comment(ctx, number, note)
+		}
+		logrus.WithFields(logrus.Fields{
+			"id": cached.ID,
+		}).Debug("Cached note not found, scanning all notes")
+	}
+
+	var user gitlabUser
+	if _, err := g.client.do(ctx, "GET", "user", nil, &user); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Debug("Failed to get the authenticated user, not filtering notes by author")
+	}
+
+	var found *gitlabNote
+	err := g.eachNote(ctx, number, func(note gitlabNote) bool {
+		if user.Username != "" && note.Author.Username != user.Username {
+			return true
+		}
+		if strings.Contains(note.Body, commentMarker(key)) {
+			found = &note
+			return false
+		}
+		return true
+	})
+	if err != nil || found == nil {
+		return nil, err
+	}
+
+	return g.comment(ctx, number, *found)
+}
+
+// ListComments returns all user notes of the merge request
+func (g *GitLab) ListComments(ctx context.Context, number int) ([]*Comment, error) {
+	var comments []*Comment
+	err := g.eachNote(ctx, number, func(note gitlabNote) bool {
+		comments = append(comments, &Comment{
+			ID:        note.ID,
+			Body:      note.Body,
+			UpdatedAt: note.UpdatedAt,
+		})
+		return true
+	})
+
+	return comments, err
+}
+
+// CreateComment posts a new note to the merge request
+func (g *GitLab) CreateComment(ctx context.Context, number int, body string) (*Comment, error) {
+	var note gitlabNote
+	_, err := g.client.do(ctx, "POST", fmt.Sprintf("projects/%s/merge_requests/%d/notes", g.project, number), map[string]string{"body": body}, &note)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.comment(ctx, number, note)
+}
+
+// EditComment replaces the body of a merge request note
+func (g *GitLab) EditComment(ctx context.Context, number int, id int64, body string) (*Comment, error) {
+	var note gitlabNote
+	_, err := g.client.do(ctx, "PUT", fmt.Sprintf("projects/%s/merge_requests/%d/notes/%d", g.project, number, id), map[string]string{"body": body}, &note)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.comment(ctx, number, note)
+}
+
+// DeleteComment deletes a merge request note
+func (g *GitLab) DeleteComment(ctx context.Context, number int, id int64) error {
+	_, err := g.client.do(ctx, "DELETE", fmt.Sprintf("projects/%s/merge_requests/%d/notes/%d", g.project, number, id), nil, nil)
+	return err
+}
+
+// eachNote calls fn with the user notes of the merge request newest-first, until fn returns false
+func (g *GitLab) eachNote(ctx context.Context, number int, fn func(gitlabNote) bool) error {
+	page := "1"
+	for page != "" {
+		var notes []gitlabNote
+		resp, err := g.client.do(ctx, "GET", fmt.Sprintf("projects/%s/merge_requests/%d/notes?sort=desc&order_by=created_at&per_page=100&page=%s", g.project, number, page), nil, &notes)
+		if err != nil {
+			return err
+		}
+		for _, note := range notes {
+			if note.System {
+				continue
+			}
+			if !fn(note) {
+				return nil
+			}
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return nil
+}
+
+// comment converts the note, linking it from the merge request page
+func (g *GitLab) comment(ctx context.Context, number int, note gitlabNote) (*Comment, error) {
+	webURL, ok := g.webURLs[number]
+	if !ok {
+		var mr gitlabMergeRequest
+		_, err := g.client.do(ctx, "GET", fmt.Sprintf("projects/%s/merge_requests/%d", g.project, number), nil, &mr)
+		if err != nil {
+			return nil, err
+		}
+		webURL = mr.WebURL
+		g.webURLs[number] = webURL
+	}
+
+	return &Comment{
+		ID:        note.ID,
+		URL:       webURL + "#note_" + strconv.FormatInt(note.ID, 10),
+		Body:      note.Body,
+		UpdatedAt: note.UpdatedAt,
+	}, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+// fakeGitLab is an in-memory stand-in for the parts of the GitLab API used by the plugin
+type fakeGitLab struct {
+	notes    []gitlabNote
+	mrs      []gitlabMergeRequest
+	perPage  int
+	nextID   int64
+	requests []string
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("PRIVATE-TOKEN") != "secret" && r.Header.Get("Authorization") != "Bearer oauth" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	// the project path is a single, escaped path segment
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/")
+	if path == "user" {
+		json.NewEncoder(w).Encode(gitlabUser{Username: "drone"})
+		return
+	}
+	if !strings.HasPrefix(path, "projects/owner%2Frepo/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "projects/owner%2Frepo/"), "/")
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case len(parts) == 4 && parts[0] == "repository" && parts[3] == "merge_requests":
+		write(f.mrs)
+	case len(parts) == 2 && parts[0] == "merge_requests":
+		iid, _ := strconv.Atoi(parts[1])
+		write(gitlabMergeRequest{IID: iid, WebURL: "https://gitlab.com/owner/repo/-/merge_requests/" + parts[1]})
+	case len(parts) == 3 && parts[2] == "notes" && r.Method == "GET":
+		f.listNotes(w, r)
+	case len(parts) == 3 && parts[2] == "notes" && r.Method == "POST":
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		note := f.add("drone", in["body"], false)
+		w.WriteHeader(http.StatusCreated)
+		write(note)
+	case len(parts) == 4 && parts[2] == "notes":
+		id, _ := strconv.ParseInt(parts[3], 10, 64)
+		for i, note := range f.notes {
+			if note.ID != id {
+				continue
+			}
+			switch r.Method {
+			case "PUT":
+				var in map[string]string
+				json.NewDecoder(r.Body).Decode(&in)
+				f.notes[i].Body = in["body"]
+				write(f.notes[i])
+			case "DELETE":
+				f.notes = append(f.notes[:i], f.notes[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				write(note)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// listNotes serves the notes newest-first, paginated with X-Next-Page like GitLab
+func (f *fakeGitLab) listNotes(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("sort") != "desc" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	start := (page - 1) * f.perPage
+	end := start + f.perPage
+	if end < len(f.notes) {
+		w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+	} else {
+		end = len(f.notes)
+	}
+
+	var notes []gitlabNote
+	for i := len(f.notes) - 1 - start; i >= len(f.notes)-end; i-- {
+		notes = append(notes, f.notes[i])
+	}
+	json.NewEncoder(w).Encode(notes)
+}
+
+func (f *fakeGitLab) add(username, body string, system bool) gitlabNote {
+	f.nextID++
+	note := gitlabNote{ID: f.nextID, Body: body, System: system}
+	note.Author.Username = username
+	f.notes = append(f.notes, note)
+
+	return note
+}
+
+func TestGitLab(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("GitLab", func() {
+		var (
+			fake   *fakeGitLab
+			server *httptest.Server
+			gitlab *GitLab
+			config Config
+			ctx    = context.Background()
+			key    = "abc"
+		)
+
+		g.BeforeEach(func() {
+			fake = &fakeGitLab{perPage: 100}
+			server = httptest.NewServer(fake)
+
+			config = Config{
+				BaseURL:   server.URL + "/api/v4/",
+				Token:     "secret",
+				RepoOwner: "owner",
+				RepoName:  "repo",
+			}
+			var err error
+			gitlab, err = NewGitLab(config, http.DefaultClient)
+			g.Assert(err == nil).IsTrue("should create the client")
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("authenticates with the private token or the OAuth password", func() {
+			_, err := gitlab.ListComments(ctx, 1)
+			g.Assert(err == nil).IsTrue("should authenticate with the private token")
+
+			gitlab, _ = NewGitLab(Config{BaseURL: config.BaseURL, Password: " oauth\n", RepoOwner: "owner", RepoName: "repo"}, http.DefaultClient)
+			_, err = gitlab.ListComments(ctx, 1)
+			g.Assert(err == nil).IsTrue("should authenticate with the OAuth token")
+
+			gitlab, _ = NewGitLab(Config{BaseURL: config.BaseURL, Password: "wrong", RepoOwner: "owner", RepoName: "repo"}, http.DefaultClient)
+			_, err = gitlab.ListComments(ctx, 1)
+			g.Assert(err.(*restError).StatusCode).Equal(http.StatusUnauthorized)
+		})
+
+		g.It("defaults to gitlab.com", func() {
+			gitlab, _ = NewGitLab(Config{BaseURL: defaultGitHubURL}, http.DefaultClient)
+			g.Assert(gitlab.client.baseURL.String()).Equal(defaultGitLabURL)
+
+			gitlab, _ = NewGitLab(Config{RemoteURL: "https://gitlab.com/owner/repo.git"}, http.DefaultClient)
+			g.Assert(gitlab.client.baseURL.String()).Equal(defaultGitLabURL)
+		})
+
+		g.It("requires a base URL for repositories on other hosts", func() {
+			_, err := NewGitLab(Config{BaseURL: defaultGitHubURL, RemoteURL: "https://gitlab.corp.example/owner/repo.git"}, http.DefaultClient)
+			g.Assert(err != nil).IsTrue("should have received error for a self-hosted repository")
+			g.Assert(err.Error()).Equal("You must provide the base URL of the GitLab API for gitlab.corp.example, e.g. https://gitlab.corp.example/api/v4/")
+		})
+
+		g.It("finds the merge request of a commit, preferring open ones", func() {
+			fake.mrs = []gitlabMergeRequest{{IID: 3, State: "merged"}, {IID: 4, State: "opened"}}
+			number, err := gitlab.PullRequestNumber(ctx, "deadbeef")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(number).Equal(4)
+			g.Assert(fake.requests[0]).Equal("GET /api/v4/projects/owner%2Frepo/repository/commits/deadbeef/merge_requests")
+
+			fake.mrs = nil
+			number, err = gitlab.PullRequestNumber(ctx, "deadbeef")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(number).Equal(0)
+		})
+
+		g.It("finds the newest note of the user across pages", func() {
+			fake.perPage = 2
+			fake.add("drone", "old plan\n"+commentMarker(key), false)
+			fake.add("drone", "new plan\n"+commentMarker(key), false)
+			for i := 0; i < 3; i++ {
+				fake.add("someone", "lgtm", false)
+			}
+			fake.add("someone", "quoting "+commentMarker(key), false)
+			fake.add("drone", commentMarker(key), true)
+
+			comment, err := gitlab.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(2))
+			g.Assert(comment.URL).Equal("https://gitlab.com/owner/repo/-/merge_requests/7#note_2")
+
+			var pages []string
+			for _, req := range fake.requests {
+				if strings.Contains(req, "/notes?") {
+					pages = append(pages, req[strings.LastIndex(req, "=")+1:])
+				}
+			}
+			// the oldest note is on page 4, which is not needed
+			g.Assert(pages).Equal([]string{"1", "2", "3"})
+		})
+
+		g.It("returns nil when there is no plugin note", func() {
+			fake.add("drone", "lgtm", false)
+
+			comment, err := gitlab.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment == nil).IsTrue("should not find a note")
+		})
+
+		g.It("finds the cached note without listing the notes", func() {
+			dir, _ := ioutil.TempDir("", "cache")
+			defer os.RemoveAll(dir)
+			config.CommentCache = filepath.Join(dir, "comments.json")
+			gitlab, _ = NewGitLab(config, http.DefaultClient)
+
+			fake.add("drone", "plan\n"+commentMarker(key), false)
+			fake.add("drone", "lgtm", false)
+			writeCommentCache(config.CommentCache, key, &Comment{ID: 1})
+
+			comment, err := gitlab.FindComment(ctx, 7, key)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(1))
+			g.Assert(fake.requests).Equal([]string{
+				"GET /api/v4/projects/owner%2Frepo/merge_requests/7/notes/1",
+				"GET /api/v4/projects/owner%2Frepo/merge_requests/7",
+			})
+		})
+
+		g.It("upserts and cleans up the plan note", func() {
+			plugin := Plugin{Config: Config{SCM: scmGitLab, RepoOwner: "owner", RepoName: "repo", Title: "Plan", IssueNum: 7}}
+			plugin.Config.scm = gitlab
+			plugin.Config.scmContext = ctx
+			key := generateKey(plugin.Config)
+
+			g.Assert(plugin.postComment("first plan") == nil).IsTrue("should not error")
+			g.Assert(plugin.postComment("second plan") == nil).IsTrue("should not error")
+			g.Assert(len(fake.notes)).Equal(1)
+			g.Assert(fake.notes[0].Body).Equal("second plan\n" + commentMarker(key) + "\n")
+
+			plugin.Config.Recreate = true
+			plugin.Config.PreviousComments = previousCommentsDelete
+			g.Assert(plugin.postComment("third plan") == nil).IsTrue("should not error")
+			g.Assert(len(fake.notes)).Equal(1)
+			g.Assert(fake.notes[0].ID).Equal(int64(2))
+		})
+
+		g.It("returns API errors", func() {
+			_, err := gitlab.EditComment(ctx, 7, 42, "plan")
+			g.Assert(err != nil).IsTrue("should have received error for a missing note")
+		})
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// lockfileModes are the values of the lockfile init option
+var lockfileModes = []string{"readonly"}
+
+// stringList is a list of strings in the settings, which may also be a single string
+// or a map, whose entries become key=value strings
+type stringList []string
+
+// UnmarshalJSON accepts a string, a list of strings or a map of values
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*l = list
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("expected a string, a list or a map, got %s", b)
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	*l = nil
+	for _, k := range keys {
+		*l = append(*l, fmt.Sprintf("%s=%v", k, m[k]))
+	}
+
+	return nil
+}
+
+// parseInitOptions decodes the init_options setting, failing on unknown options
+func parseInitOptions(s string) (InitOptions, error) {
+	var options InitOptions
+	if s == "" {
+		return options, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader([]byte(s)))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&options); err != nil {
+		return options, fmt.Errorf("Failed to parse init options. %s", err)
+	}
+
+	if options.Lockfile != "" && !contains(lockfileModes, options.Lockfile) {
+		return options, fmt.Errorf("Init option lockfile is invalid, required one of [%s]", strings.Join(lockfileModes, ","))
+	}
+
+	return options, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestInitOptions(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("parseInitOptions", func() {
+		g.It("accepts the backend config as a list, a string or a map", func() {
+			options, err := parseInitOptions(`{"backend-config": ["bucket=state", "key=prod.tfstate"]}`)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert([]string(options.BackendConfig)).Equal([]string{"bucket=state", "key=prod.tfstate"})
+
+			options, err = parseInitOptions(`{"backend-config": "backend.hcl"}`)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert([]string(options.BackendConfig)).Equal([]string{"backend.hcl"})
+
+			options, err = parseInitOptions(`{"backend-config": {"key": "prod.tfstate", "bucket": "state", "encrypt": true}}`)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert([]string(options.BackendConfig)).Equal([]string{"bucket=state", "encrypt=true", "key=prod.tfstate"})
+		})
+
+		g.It("fails on unknown options", func() {
+			_, err := parseInitOptions(`{"backend_config": ["bucket=state"]}`)
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(strings.Contains(err.Error(), `unknown field "backend_config"`)).IsTrue("should name the unknown option")
+		})
+
+		g.It("fails on invalid options", func() {
+			_, err := parseInitOptions(`{"lockfile": "readwrite"}`)
+			g.Assert(err != nil).IsTrue("should have received error")
+
+			_, err = parseInitOptions(`{"upgrade": "yes"}`)
+			g.Assert(err != nil).IsTrue("should have received error")
+
+			_, err = parseInitOptions(`not json`)
+			g.Assert(err != nil).IsTrue("should have received error")
+		})
+	})
+
+	g.Describe("initCommand", func() {
+		g.It("passes every option to terraform init", func() {
+			options, err := parseInitOptions(`{
+				"backend": true,
+				"backend-config": {"bucket": "state"},
+				"from-module": "git::https://example.com/module.git",
+				"lock": false,
+				"lock-timeout": "5m",
+				"lockfile": "readonly",
+				"migrate-state": false,
+				"plugin-dir": ["/plugins/a", "/plugins/b"],
+				"reconfigure": true,
+				"upgrade": true
+			}`)
+			g.Assert(err == nil).IsTrue("should not error")
+
+			g.Assert(initCommand(options).Args).Equal([]string{
+				"terraform", "init",
+				"-backend=true",
+				"-backend-config=bucket=state",
+				"-from-module=git::https://example.com/module.git",
+				"-lock=false",
+				"-lock-timeout=5m",
+				"-lockfile=readonly",
+				"-migrate-state=false",
+				"-plugin-dir=/plugins/a",
+				"-plugin-dir=/plugins/b",
+				"-reconfigure",
+				"-upgrade",
+				"-input=false",
+			})
+		})
+
+		g.It("only fails on prompts by default", func() {
+			g.Assert(initCommand(InitOptions{}).Args).Equal([]string{"terraform", "init", "-input=false"})
+		})
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+const (
+	labelNoChanges = "no-changes"
+	labelDestroy   = "destroy"
+	labelReplace   = "replace"
+	labelStack     = "stack"
+)
+
+// planLabels are the labels owned by the plugin that depend on the plan content
+var planLabels = []string{labelNoChanges, labelDestroy, labelReplace}
+
+var defaultLabelColors = map[string]string{
+	labelNoChanges: "0e8a16",
+	labelDestroy:   "b60205",
+	labelReplace:   "d93f0b",
+	labelStack:     "1d76db",
+}
+
+// applyLabels labels the PR according to the plan and removes the plan labels that no
+// longer apply, leaving the stack labels of other stacks alone
+func (p Plugin) applyLabels(plan *parser.Plan) error {
+	l, ok := p.Config.scm.(labeler)
+	if !ok {
+		return fmt.Errorf("Labels are not supported by %s", p.Config.SCM)
+	}
+
+	desired := p.labelsFor(plan)
+
+	var stale []string
+	for _, name := range planLabels {
+		if label := p.Config.LabelPrefix + name; !contains(desired, label) {
+			stale = append(stale, label)
+		}
+	}
+
+	colors := map[string]string{}
+	for _, label := range desired {
+		colors[label] = p.labelColor(label)
+	}
+
+	return l.SetLabels(p.Config.scmContext, p.Config.IssueNum, desired, stale, colors)
+}
+
+// SetLabels adds the labels to the issue, creating them in the repository when missing,
+// and removes the stale labels
+func (g *GitHub) SetLabels(ctx context.Context, number int, labels []string, stale []string, colors map[string]string) error {
+	current, err := g.issueLabels(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	for _, label := range stale {
+		if !contains(current, label) {
+			continue
+		}
+		_, err := g.client.Issues.RemoveLabelForIssue(ctx, g.owner, g.repo, number, url.PathEscape(label))
+		if err != nil {
+			return fmt.Errorf("Failed to remove label %s from PR #%d. %s", label, number, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"label": label,
+		}).Info("Removed label from PR")
+	}
+
+	var missing []string
+	for _, label := range labels {
+		if contains(current, label) {
+			continue
+		}
+		if err := g.ensureLabel(ctx, label, colors[label]); err != nil {
+			return err
+		}
+		missing = append(missing, label)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	_, _, err = g.client.Issues.AddLabelsToIssue(ctx, g.owner, g.repo, number, missing)
+	if err != nil {
+		return fmt.Errorf("Failed to add labels to PR #%d. %s", number, err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"labels": strings.Join(missing, ","),
+	}).Info("Added labels to PR")
+
+	return nil
+}
+
+func (p Plugin) labelsFor(plan *parser.Plan) []string {
+	var labels []string
+
+	if !plan.HasChanges() {
+		labels = append(labels, p.Config.LabelPrefix+labelNoChanges)
+	}
+	if len(plan.Addresses(parser.ActionDelete)) > 0 {
+		labels = append(labels, p.Config.LabelPrefix+labelDestroy)
+	}
+	if len(plan.Addresses(parser.ActionReplace)) > 0 {
+		labels = append(labels, p.Config.LabelPrefix+labelReplace)
+	}
+	if p.Config.Stack != "" {
+		labels = append(labels, fmt.Sprintf("%s%s/%s", p.Config.LabelPrefix, labelStack, p.Config.Stack))
+	}
+
+	return labels
+}
+
+func (g *GitHub) issueLabels(ctx context.Context, number int) ([]string, error) {
+	var names []string
+
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		labels, resp, err := g.client.Issues.ListLabelsByIssue(ctx, g.owner, g.repo, number, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list labels of PR #%d. %s", number, err)
+		}
+		for _, label := range labels {
+			names = append(names, label.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return names, nil
+}
+
+// ensureLabel creates the label in the repository with the color, when missing
+func (g *GitHub) ensureLabel(ctx context.Context, name string, color string) error {
+	_, resp, err := g.client.Issues.GetLabel(ctx, g.owner, g.repo, url.PathEscape(name))
+	if err == nil {
+		return nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Failed to get label %s. %s", name, err)
+	}
+
+	label := &github.Label{
+		Name:  github.String(name),
+		Color: github.String(color),
+	}
+	_, _, err = g.client.Issues.CreateLabel(ctx, g.owner, g.repo, label)
+	if err != nil {
+		return fmt.Errorf("Failed to create label %s. %s", name, err)
+	}
+
+	return nil
+}
+
+// labelColor looks up the color by label name without the prefix, where stack labels
+// share the color of the "stack" key
+func (p Plugin) labelColor(label string) string {
+	name := strings.TrimPrefix(label, p.Config.LabelPrefix)
+	if strings.HasPrefix(name, labelStack+"/") {
+		name = labelStack
+	}
+
+	if color, ok := p.Config.LabelColors[name]; ok {
+		return strings.TrimPrefix(color, "#")
+	}
+
+	return defaultLabelColors[name]
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+// fakeLabels is an in-memory stand-in for the repository and issue labels API
+type fakeLabels struct {
+	repo  map[string]string
+	issue []string
+}
+
+func (f *fakeLabels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	name := func(prefix string) string {
+		n, _ := url.PathUnescape(strings.TrimPrefix(path, prefix))
+		return n
+	}
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case path == "/repos/owner/repo/issues/7/labels" && r.Method == "GET":
+		var labels []map[string]string
+		for _, label := range f.issue {
+			labels = append(labels, map[string]string{"name": label})
+		}
+		write(labels)
+	case path == "/repos/owner/repo/issues/7/labels" && r.Method == "POST":
+		var labels []string
+		json.NewDecoder(r.Body).Decode(&labels)
+		f.issue = append(f.issue, labels...)
+		write([]interface{}{})
+	case strings.HasPrefix(path, "/repos/owner/repo/issues/7/labels/") && r.Method == "DELETE":
+		label := name("/repos/owner/repo/issues/7/labels/")
+		for i, l := range f.issue {
+			if l == label {
+				f.issue = append(f.issue[:i], f.issue[i+1:]...)
+				break
+			}
+		}
+		write([]interface{}{})
+	case strings.HasPrefix(path, "/repos/owner/repo/labels/") && r.Method == "GET":
+		label := name("/repos/owner/repo/labels/")
+		if _, ok := f.repo[label]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		write(map[string]string{"name": label, "color": f.repo[label]})
+	case path == "/repos/owner/repo/labels" && r.Method == "POST":
+		var label map[string]string
+		json.NewDecoder(r.Body).Decode(&label)
+		f.repo[label["name"]] = label["color"]
+		w.WriteHeader(http.StatusCreated)
+		write(label)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	destroy := &parser.Plan{ResourceChanges: []parser.ResourceChange{
+		{Address: "aws_s3_bucket.logs", Change: parser.Change{Actions: []string{"delete"}}},
+		{Address: "aws_instance.web", Change: parser.Change{Actions: []string{"create"}}},
+	}}
+
+	g.Describe("labelsFor", func() {
+		g.It("labels plans without changes", func() {
+			p := Plugin{Config: Config{LabelPrefix: "tf/", Stack: "prod"}}
+			g.Assert(p.labelsFor(&parser.Plan{})).Equal([]string{"tf/no-changes", "tf/stack/prod"})
+		})
+
+		g.It("labels destroyed and replaced resources", func() {
+			p := Plugin{}
+			plan := &parser.Plan{ResourceChanges: append(destroy.ResourceChanges, parser.ResourceChange{
+				Address: "aws_kms_key.main",
+				Change:  parser.Change{Actions: []string{"delete", "create"}},
+			})}
+			g.Assert(p.labelsFor(plan)).Equal([]string{"destroy", "replace"})
+		})
+	})
+
+	g.Describe("labelColor", func() {
+		g.It("uses the configured colors without the prefix", func() {
+			p := Plugin{Config: Config{LabelPrefix: "tf/", LabelColors: map[string]string{"destroy": "#000000", "stack": "ffffff"}}}
+			g.Assert(p.labelColor("tf/destroy")).Equal("000000")
+			g.Assert(p.labelColor("tf/stack/prod")).Equal("ffffff")
+			g.Assert(p.labelColor("tf/replace")).Equal(defaultLabelColors[labelReplace])
+		})
+	})
+
+	g.Describe("applyLabels", func() {
+		var fake *fakeLabels
+		var server *httptest.Server
+		var plugin Plugin
+
+		g.BeforeEach(func() {
+			fake = &fakeLabels{
+				repo:  map[string]string{"tf/no-changes": "0e8a16", "tf/stack/prod": "1d76db"},
+				issue: []string{"bug", "tf/no-changes", "tf/stack/other"},
+			}
+			server = httptest.NewServer(fake)
+			plugin = Plugin{
+				Config: Config{
+					SCM:         scmGitHub,
+					BaseURL:     server.URL + "/",
+					Token:       "secret",
+					RepoOwner:   "owner",
+					RepoName:    "repo",
+					IssueNum:    7,
+					LabelPrefix: "tf/",
+					Stack:       "prod",
+				},
+			}
+			plugin.Config.scmContext = context.Background()
+			plugin.Config.scm, _ = newSCM(plugin.Config)
+		})
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("replaces the plan labels and keeps the others", func() {
+			g.Assert(plugin.applyLabels(destroy) == nil).IsTrue("should not error")
+
+			sort.Strings(fake.issue)
+			g.Assert(fake.issue).Equal([]string{"bug", "tf/destroy", "tf/stack/other", "tf/stack/prod"})
+			g.Assert(fake.repo["tf/destroy"]).Equal(defaultLabelColors[labelDestroy])
+		})
+
+		g.It("does not add the labels the PR already has", func() {
+			g.Assert(plugin.applyLabels(&parser.Plan{}) == nil).IsTrue("should not error")
+			g.Assert(plugin.applyLabels(&parser.Plan{}) == nil).IsTrue("should not error")
+
+			sort.Strings(fake.issue)
+			g.Assert(fake.issue).Equal([]string{"bug", "tf/no-changes", "tf/stack/other", "tf/stack/prod"})
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+const commentsPerPage = 100
+
+type (
+	// cachedComment is the identity of a plugin comment saved between builds
+	cachedComment struct {
+		ID        int64     `json:"id"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+)
+
+// FindComment looks up the plugin comment, first from the comment cache and then by
+// scanning the issue comments newest-first, stopping at the first match
+func (g *GitHub) FindComment(ctx context.Context, number int, key string) (*Comment, error) {
+	if cached, ok := readCommentCache(g.commentCache)[key]; ok {
+		comment, err := g.findCachedComment(ctx, number, key, cached)
+		if err != nil {
+			return nil, err
+		}
+		if comment != nil {
+			return githubComment(comment), nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"id": cached.ID,
+		}).Debug("Cached comment not found, scanning all comments")
+	}
+
+	author := g.authenticatedLogin(ctx)
+
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: commentsPerPage},
+	}
+	comments, resp, err := g.client.Issues.ListComments(ctx, g.owner, g.repo, number, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// comments are returned oldest-first, so walk the pages backwards from the last one
+	for page := resp.LastPage; page > 1; page-- {
+		opts.Page = page
+		pageComments, _, err := g.client.Issues.ListComments(ctx, g.owner, g.repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		if comment := findNewestComment(pageComments, key, author); comment != nil {
+			return githubComment(comment), nil
+		}
+	}
+
+	if comment := findNewestComment(comments, key, author); comment != nil {
+		return githubComment(comment), nil
+	}
+
+	return nil, nil
+}
+
+// findCachedComment lists only the comments updated since the cached one was written,
+// which includes the cached comment unless it has been deleted
+func (g *GitHub) findCachedComment(ctx context.Context, number int, key string, cached cachedComment) (*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{
+		Since:       cached.UpdatedAt,
+		ListOptions: github.ListOptions{PerPage: commentsPerPage},
+	}
+
+	for {
+		comments, resp, err := g.client.Issues.ListComments(ctx, g.owner, g.repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			if comment.GetID() == cached.ID && strings.Contains(comment.GetBody(), commentMarker(key)) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// authenticatedLogin returns the login of the user the plugin posts as, or an empty
+// string when it cannot be determined (e.g. for GitHub App installation tokens)
+func (g *GitHub) authenticatedLogin(ctx context.Context) string {
+	user, _, err := g.client.Users.Get(ctx, "")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Debug("Failed to get the authenticated user, not filtering comments by author")
+		return ""
+	}
+
+	return user.GetLogin()
+}
+
+func findNewestComment(comments []*github.IssueComment, key string, author string) *github.IssueComment {
+	for i := len(comments) - 1; i >= 0; i-- {
+		comment := comments[i]
+		if author != "" && comment.GetUser().GetLogin() != author {
+			continue
+		}
+		if strings.Contains(comment.GetBody(), commentMarker(key)) {
+			return comment
+		}
+	}
+
+	return nil
+}
+
+func readCommentCache(path string) map[string]cachedComment {
+	cache := map[string]cachedComment{}
+	if path == "" {
+		return cache
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Ignoring invalid comment cache")
+		return map[string]cachedComment{}
+	}
+
+	return cache
+}
+
+// writeCommentCache saves the comment identity under its key, keeping other keys intact
+func writeCommentCache(path string, key string, comment *Comment) error {
+	if path == "" {
+		return nil
+	}
+
+	cache := readCommentCache(path)
+	cache[key] = cachedComment{
+		ID:        comment.ID,
+		UpdatedAt: comment.UpdatedAt,
+	}
+
+	out, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, out, 0644)
+}
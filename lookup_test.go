@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestFindComment(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("FindComment", func() {
+		var gh *fakeGitHub
+		var server *httptest.Server
+		var client *GitHub
+		var dir, cache string
+
+		// listPages returns the page and since parameters of the comment list requests
+		listPages := func() []string {
+			var pages []string
+			for _, req := range gh.requests {
+				if !strings.HasPrefix(req, "GET /repos/owner/repo/issues/7/comments") {
+					continue
+				}
+				u, _ := url.Parse(strings.TrimPrefix(req, "GET "))
+				page := u.Query().Get("page")
+				if u.Query().Get("since") != "" {
+					page = "since"
+				}
+				pages = append(pages, page)
+			}
+			return pages
+		}
+
+		g.BeforeEach(func() {
+			gh = &fakeGitHub{login: "drone"}
+			server = httptest.NewServer(gh)
+			dir, _ = ioutil.TempDir("", "cache")
+			cache = filepath.Join(dir, "comments.json")
+
+			scm, _ := newSCM(Config{
+				BaseURL:      server.URL + "/",
+				Token:        "secret",
+				SCM:          scmGitHub,
+				RepoOwner:    "owner",
+				RepoName:     "repo",
+				CommentCache: cache,
+			})
+			client = scm.(*GitHub)
+		})
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		g.It("finds the cached comment with only the comments since", func() {
+			gh.add("plan\n"+commentMarker("key"), "drone")
+			cached := gh.add("plan\n"+commentMarker("key"), "drone")
+			gh.add("LGTM", "reviewer")
+			writeCommentCache(cache, "key", githubComment(cached))
+			gh.requests = nil
+
+			comment, err := client.FindComment(context.Background(), 7, "key")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(cached.GetID())
+			g.Assert(listPages()).Equal([]string{"since"})
+			g.Assert(len(gh.requests)).Equal(1)
+		})
+
+		g.It("scans the comments when the cached comment is gone", func() {
+			newest := gh.add("plan\n"+commentMarker("key"), "drone")
+			writeCommentCache(cache, "key", &Comment{ID: 42, UpdatedAt: newest.GetUpdatedAt()})
+			gh.requests = nil
+
+			comment, err := client.FindComment(context.Background(), 7, "key")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(newest.GetID())
+			g.Assert(listPages()).Equal([]string{"since", ""})
+		})
+
+		g.It("walks the pages backwards from the last one", func() {
+			for i := 1; i <= 250; i++ {
+				body := "comment"
+				if i == 10 || i == 120 {
+					body = "plan\n" + commentMarker("key")
+				}
+				gh.add(body, "drone")
+			}
+			gh.requests = nil
+
+			comment, err := client.FindComment(context.Background(), 7, "key")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(int64(120))
+			g.Assert(listPages()).Equal([]string{"", "3", "2"})
+		})
+
+		g.It("only matches the comments of the plugin user", func() {
+			drone := gh.add("plan\n"+commentMarker("key"), "drone")
+			gh.add("quoting the plan\n"+commentMarker("key"), "mallory")
+
+			comment, err := client.FindComment(context.Background(), 7, "key")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(drone.GetID())
+
+			// the user of GitHub App tokens cannot be looked up
+			gh.login = ""
+			comment, err = client.FindComment(context.Background(), 7, "key")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment.ID).Equal(drone.GetID() + 1)
+		})
+
+		g.It("returns nil without a comment", func() {
+			gh.add("LGTM", "reviewer")
+
+			comment, err := client.FindComment(context.Background(), 7, "key")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(comment == nil).IsTrue("should not find a comment")
+		})
+	})
+
+	g.Describe("comment cache", func() {
+		g.It("keeps the other keys and ignores invalid caches", func() {
+			dir, _ := ioutil.TempDir("", "cache")
+			defer os.RemoveAll(dir)
+			cache := filepath.Join(dir, "nested", "comments.json")
+
+			g.Assert(writeCommentCache(cache, "a", &Comment{ID: 1}) == nil).IsTrue("should not error")
+			g.Assert(writeCommentCache(cache, "b", &Comment{ID: 2}) == nil).IsTrue("should not error")
+			entries := readCommentCache(cache)
+			g.Assert(entries["a"].ID).Equal(int64(1))
+			g.Assert(entries["b"].ID).Equal(int64(2))
+
+			ioutil.WriteFile(cache, []byte("{"), 0644)
+			g.Assert(len(readCommentCache(cache))).Equal(0)
+			g.Assert(len(readCommentCache(""))).Equal(0)
+		})
+	})
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -23,8 +25,8 @@ func main() {
 		//
 		cli.StringFlag{
 			Name:   "api-key",
-			Usage:  "api key to access github api",
-			EnvVar: "PLUGIN_API_KEY,GITHUB_RELEASE_API_KEY,GITHUB_TOKEN",
+			Usage:  "api key to access the scm api, falls back to the token secret of the scm",
+			EnvVar: "PLUGIN_API_KEY",
 		},
 		cli.StringFlag{
 			Name:   "username",
@@ -38,10 +40,15 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "base-url",
-			Value:  "https://api.github.com/",
-			Usage:  "api url, needs to be changed for ghe",
+			Value:  defaultGitHubURL,
+			Usage:  "api url, needs to be changed for ghe, self-hosted gitlab, gitea and bitbucket",
 			EnvVar: "PLUGIN_BASE_URL,GITHUB_BASE_URL",
 		},
+		cli.StringFlag{
+			Name:   "scm",
+			Usage:  "scm to post the comment to [github, gitlab, gitea, bitbucket], detected from the repository url when not set",
+			EnvVar: "PLUGIN_SCM",
+		},
 		cli.StringFlag{
 			Name:   "title",
 			Value:  "Terraform Plan Output",
@@ -54,16 +61,111 @@ func main() {
 			Usage:  "comment mode [summary, simple, full]",
 			EnvVar: "PLUGIN_MODE",
 		},
+		cli.StringSliceFlag{
+			Name:   "output",
+			Value:  &cli.StringSlice{"comment"},
+			Usage:  "where to post the plan [comment, review]",
+			EnvVar: "PLUGIN_OUTPUT",
+		},
+		cli.StringFlag{
+			Name:   "output_file",
+			Usage:  "file to write the id and url of the posted comment to, as json",
+			EnvVar: "PLUGIN_OUTPUT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "comment_cache",
+			Usage:  "file to remember the comment id in between builds, for use with a drone cache",
+			EnvVar: "PLUGIN_COMMENT_CACHE",
+		},
+		cli.BoolFlag{
+			Name:   "labels",
+			Usage:  "label the PR according to the plan",
+			EnvVar: "PLUGIN_LABELS",
+		},
+		cli.StringFlag{
+			Name:   "label_prefix",
+			Value:  "terraform:",
+			Usage:  "prefix of the labels managed by the plugin",
+			EnvVar: "PLUGIN_LABEL_PREFIX",
+		},
+		cli.StringFlag{
+			Name:   "label_colors",
+			Usage:  "colors of the labels created by the plugin, as a json map of label name without prefix to hex color",
+			EnvVar: "PLUGIN_LABEL_COLORS",
+		},
+		cli.StringFlag{
+			Name:   "stack",
+			Usage:  "name of the terraform stack, used for the stack label",
+			EnvVar: "PLUGIN_STACK",
+		},
+		cli.StringSliceFlag{
+			Name:   "fail_on",
+			Usage:  "fail the build after commenting when the plan has any of [destroy, replace]",
+			EnvVar: "PLUGIN_FAIL_ON",
+		},
+		cli.IntFlag{
+			Name:   "max_destroy",
+			Usage:  "fail the build after commenting when the plan destroys more resources, no limit when unset",
+			EnvVar: "PLUGIN_MAX_DESTROY",
+		},
+		cli.IntFlag{
+			Name:   "max_replace",
+			Usage:  "fail the build after commenting when the plan replaces more resources, no limit when unset",
+			EnvVar: "PLUGIN_MAX_REPLACE",
+		},
+		cli.StringSliceFlag{
+			Name:   "protected_resources",
+			Usage:  "address or resource type glob patterns of resources the plan must not destroy or replace",
+			EnvVar: "PLUGIN_PROTECTED_RESOURCES",
+		},
+		cli.StringFlag{
+			Name:   "policy_file",
+			Usage:  "yaml file with rules to check the plan against",
+			EnvVar: "PLUGIN_POLICY_FILE",
+		},
+		cli.StringFlag{
+			Name:   "policy_dir",
+			Usage:  "directory of rego policies to check the plan against",
+			EnvVar: "PLUGIN_POLICY_DIR",
+		},
+		cli.StringSliceFlag{
+			Name:   "mask_patterns",
+			Usage:  "regular expressions of values to redact from the comment",
+			EnvVar: "PLUGIN_MASK_PATTERNS",
+		},
+		cli.StringSliceFlag{
+			Name:   "secret_allowlist",
+			Usage:  "regular expressions of values or resource addresses the secret scan ignores",
+			EnvVar: "PLUGIN_SECRET_ALLOWLIST",
+		},
 		cli.IntFlag{
 			Name:   "issue-num",
 			Usage:  "Issue #",
 			EnvVar: "PLUGIN_ISSUE_NUM,DRONE_PULL_REQUEST",
 		},
+		cli.IntFlag{
+			Name:   "max_retries",
+			Value:  defaultRetryAttempts,
+			Usage:  "how many times to retry api calls failing with rate limits or server errors",
+			EnvVar: "PLUGIN_MAX_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Value:  defaultRetryTimeout,
+			Usage:  "overall time budget for an api call including retries",
+			EnvVar: "PLUGIN_TIMEOUT",
+		},
 		cli.BoolFlag{
 			Name:   "recreate",
 			Usage:  "recreate the comment every time",
 			EnvVar: "PLUGIN_RECREATE",
 		},
+		cli.StringFlag{
+			Name:   "previous_comments",
+			Value:  "keep",
+			Usage:  "what to do with comments of previous builds when recreating [keep, minimize, delete]",
+			EnvVar: "PLUGIN_PREVIOUS_COMMENTS",
+		},
 		cli.StringFlag{
 			Name:   "tf_root_dir",
 			Usage:  "The root directory where the terraform files live. When unset, the top level directory will be assumed",
@@ -80,11 +182,93 @@ func main() {
 			Usage:  "changes the location where Terraform keeps its per-working-directory data, such as the current remote backend configuration",
 			EnvVar: "PLUGIN_TF_DATA_DIR",
 		},
+		cli.BoolTFlag{
+			Name:   "clean_data_dir",
+			Usage:  "delete the data dir before init, except for the providers",
+			EnvVar: "PLUGIN_CLEAN_DATA_DIR",
+		},
+		cli.StringFlag{
+			Name:   "plugin_cache_dir",
+			Usage:  "directory where terraform caches the provider plugins",
+			EnvVar: "PLUGIN_PLUGIN_CACHE_DIR",
+		},
 		cli.StringFlag{
+			Name:   "plan_json",
+			Usage:  "file with the output of terraform show -json, to render instead of running terraform",
+			EnvVar: "PLUGIN_PLAN_JSON",
+		},
+		cli.StringFlag{
+			Name:   "env",
+			Usage:  "environment variables of the terraform commands as a JSON object",
+			EnvVar: "PLUGIN_ENV",
+		},
+		cli.StringFlag{
+			Name:   "env_file",
+			Usage:  "file with environment variables of the terraform commands",
+			EnvVar: "PLUGIN_ENV_FILE",
+		},
+		cli.StringSliceFlag{
 			Name:   "ca_cert",
-			Usage:  "ca cert to add to your environment to allow terraform to use internal/private resources",
+			Usage:  "ca certs, as PEM or files, to trust for terraform and the SCM API to allow internal/private resources",
 			EnvVar: "PLUGIN_CA_CERT",
 		},
+		cli.StringFlag{
+			Name:   "tfc_address",
+			Value:  defaultTFCAddress,
+			Usage:  "address of Terraform Cloud or Enterprise",
+			EnvVar: "PLUGIN_TFC_ADDRESS,TFE_ADDRESS",
+		},
+		cli.StringFlag{
+			Name:   "tfc_token",
+			Usage:  "Terraform Cloud API token",
+			EnvVar: "PLUGIN_TFC_TOKEN,TFC_TOKEN,TFE_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "tfc_organization",
+			Usage:  "Terraform Cloud organization of the workspace",
+			EnvVar: "PLUGIN_TFC_ORGANIZATION",
+		},
+		cli.StringFlag{
+			Name:   "tfc_workspace",
+			Usage:  "Terraform Cloud workspace to find the speculative run of the commit in",
+			EnvVar: "PLUGIN_TFC_WORKSPACE",
+		},
+		cli.StringFlag{
+			Name:   "tfc_run_id",
+			Usage:  "Terraform Cloud run to render the plan of",
+			EnvVar: "PLUGIN_TFC_RUN_ID",
+		},
+		cli.DurationFlag{
+			Name:   "tfc_timeout",
+			Value:  defaultTFCTimeout,
+			Usage:  "how long to wait for the plan of the Terraform Cloud run",
+			EnvVar: "PLUGIN_TFC_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "netrc_entries",
+			Usage:  "additional netrc entries as a JSON list of objects with machine, login and password",
+			EnvVar: "PLUGIN_NETRC_ENTRIES",
+		},
+		cli.StringFlag{
+			Name:   "registry_credentials",
+			Usage:  "tokens of private registries as a JSON object of host to token",
+			EnvVar: "PLUGIN_REGISTRY_CREDENTIALS",
+		},
+		cli.StringFlag{
+			Name:   "ssh_key",
+			Usage:  "private SSH key to fetch git::ssh:// module sources",
+			EnvVar: "PLUGIN_SSH_KEY,SSH_KEY",
+		},
+		cli.StringFlag{
+			Name:   "ssh_known_hosts",
+			Usage:  "known hosts to check the host keys of git::ssh:// module sources",
+			EnvVar: "PLUGIN_SSH_KNOWN_HOSTS",
+		},
+		cli.BoolFlag{
+			Name:   "ssh_skip_host_key_check",
+			Usage:  "do not check the host keys of git::ssh:// module sources",
+			EnvVar: "PLUGIN_SSH_SKIP_HOST_KEY_CHECK",
+		},
 		cli.StringFlag{
 			Name:   "init_options",
 			Usage:  "options for the init command. See https://www.terraform.io/docs/commands/init.html",
@@ -100,6 +284,43 @@ func main() {
 			Usage:  "A role to assume before running the terraform commands",
 			EnvVar: "PLUGIN_ROLE_ARN_TO_ASSUME",
 		},
+		cli.StringFlag{
+			Name:   "role_session_name",
+			Usage:  "session name of the assumed role",
+			Value:  defaultRoleSessionName,
+			EnvVar: "PLUGIN_ROLE_SESSION_NAME",
+		},
+		cli.StringFlag{
+			Name:   "role_external_id",
+			Usage:  "external ID to assume the role with",
+			EnvVar: "PLUGIN_ROLE_EXTERNAL_ID",
+		},
+		cli.DurationFlag{
+			Name:   "role_duration",
+			Usage:  "duration of the assumed role session",
+			Value:  defaultRoleDuration,
+			EnvVar: "PLUGIN_ROLE_DURATION",
+		},
+		cli.StringSliceFlag{
+			Name:   "role_chain",
+			Usage:  "roles to assume in order with the credentials of the previous role",
+			EnvVar: "PLUGIN_ROLE_CHAIN",
+		},
+		cli.StringFlag{
+			Name:   "role_session_tags",
+			Usage:  "session tags of the assumed role as a JSON object",
+			EnvVar: "PLUGIN_ROLE_SESSION_TAGS",
+		},
+		cli.StringFlag{
+			Name:   "role_web_identity_token",
+			Usage:  "OIDC token to assume the role with web identity",
+			EnvVar: "PLUGIN_ROLE_WEB_IDENTITY_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "role_web_identity_token_file",
+			Usage:  "file with the OIDC token to assume the role with web identity",
+			EnvVar: "PLUGIN_ROLE_WEB_IDENTITY_TOKEN_FILE,AWS_WEB_IDENTITY_TOKEN_FILE",
+		},
 
 		//
 		// drone env
@@ -115,6 +336,11 @@ func main() {
 			Usage:  "repository owner",
 			EnvVar: "DRONE_REPO_OWNER",
 		},
+		cli.StringFlag{
+			Name:   "remote_url",
+			Usage:  "repository url",
+			EnvVar: "DRONE_GIT_HTTP_URL,DRONE_REPO_LINK,DRONE_REMOTE_URL",
+		},
 		cli.StringFlag{
 			Name:   "commit-sha",
 			Usage:  "git commit SHA",
@@ -151,28 +377,118 @@ func run(c *cli.Context) error {
 		"Revision": revision,
 	}).Info("Drone Terraform GitHub Commenter Plugin Version")
 
-	initOptions := InitOptions{}
-	json.Unmarshal([]byte(c.String("init_options")), &initOptions)
+	initOptions, err := parseInitOptions(c.String("init_options"))
+	if err != nil {
+		return err
+	}
+
+	labelColors := map[string]string{}
+	if c.String("label_colors") != "" {
+		if err := json.Unmarshal([]byte(c.String("label_colors")), &labelColors); err != nil {
+			return fmt.Errorf("Failed to parse label colors. %s", err)
+		}
+	}
+
+	// values may be numbers or booleans in the YAML settings
+	env := map[string]string{}
+	if c.String("env") != "" {
+		var values map[string]interface{}
+		if err := json.Unmarshal([]byte(c.String("env")), &values); err != nil {
+			return fmt.Errorf("Failed to parse env. %s", err)
+		}
+		for k, v := range values {
+			env[k] = fmt.Sprint(v)
+		}
+	}
+
+	roleTags := map[string]string{}
+	if c.String("role_session_tags") != "" {
+		if err := json.Unmarshal([]byte(c.String("role_session_tags")), &roleTags); err != nil {
+			return fmt.Errorf("Failed to parse role session tags. %s", err)
+		}
+	}
+
+	var netrcEntries []Netrc
+	if c.String("netrc_entries") != "" {
+		if err := json.Unmarshal([]byte(c.String("netrc_entries")), &netrcEntries); err != nil {
+			return fmt.Errorf("Failed to parse netrc entries. %s", err)
+		}
+	}
+
+	registryTokens := map[string]string{}
+	if c.String("registry_credentials") != "" {
+		if err := json.Unmarshal([]byte(c.String("registry_credentials")), &registryTokens); err != nil {
+			return fmt.Errorf("Failed to parse registry credentials. %s", err)
+		}
+	}
+
+	stack := c.String("stack")
+	if stack == "" && c.String("tf_root_dir") != "" {
+		stack = filepath.Base(c.String("tf_root_dir"))
+	}
 
 	plugin := Plugin{
 		Config: Config{
-			BaseURL:          c.String("base-url"),
-			Mode:             c.String("mode"),
-			Title:            c.String("title"),
-			IssueNum:         c.Int("issue-num"),
-			Password:         c.String("password"),
-			RepoName:         c.String("repo-name"),
-			RepoOwner:        c.String("repo-owner"),
-			CommitSha:        c.String("commit-sha"),
-			Token:            c.String("api-key"),
-			Recreate:         c.Bool("recreate"),
-			Username:         c.String("username"),
-			InitOptions:      initOptions,
-			Cacert:           c.String("ca_cert"),
-			Debug:            c.Bool("debug"),
-			RoleARN:          c.String("role_arn_to_assume"),
-			TerraformRootDir: c.String("tf_root_dir"),
-			TerraformDataDir: c.String("tf_data_dir"),
+			BaseURL:            c.String("base-url"),
+			SCM:                c.String("scm"),
+			RemoteURL:          c.String("remote_url"),
+			Mode:               c.String("mode"),
+			Title:              c.String("title"),
+			IssueNum:           c.Int("issue-num"),
+			Password:           c.String("password"),
+			RepoName:           c.String("repo-name"),
+			RepoOwner:          c.String("repo-owner"),
+			CommitSha:          c.String("commit-sha"),
+			Token:              c.String("api-key"),
+			Recreate:           c.Bool("recreate"),
+			PreviousComments:   c.String("previous_comments"),
+			OutputFile:         c.String("output_file"),
+			CommentCache:       c.String("comment_cache"),
+			Outputs:            c.StringSlice("output"),
+			Labels:             c.Bool("labels"),
+			LabelPrefix:        c.String("label_prefix"),
+			LabelColors:        labelColors,
+			Stack:              stack,
+			FailOn:             c.StringSlice("fail_on"),
+			MaxDestroy:         optionalInt(c, "max_destroy"),
+			MaxReplace:         optionalInt(c, "max_replace"),
+			ProtectedResources: c.StringSlice("protected_resources"),
+			PolicyFile:         c.String("policy_file"),
+			PolicyDir:          c.String("policy_dir"),
+			MaskPatterns:       c.StringSlice("mask_patterns"),
+			SecretAllowlist:    c.StringSlice("secret_allowlist"),
+			Username:           c.String("username"),
+			InitOptions:        initOptions,
+			CACerts:            c.StringSlice("ca_cert"),
+			TFCAddress:         c.String("tfc_address"),
+			TFCToken:           c.String("tfc_token"),
+			TFCOrganization:    c.String("tfc_organization"),
+			TFCWorkspace:       c.String("tfc_workspace"),
+			TFCRunID:           c.String("tfc_run_id"),
+			TFCTimeout:         c.Duration("tfc_timeout"),
+			NetrcEntries:       netrcEntries,
+			RegistryTokens:     registryTokens,
+			SSHKey:             c.String("ssh_key"),
+			SSHKnownHosts:      c.String("ssh_known_hosts"),
+			SSHSkipHostKeys:    c.Bool("ssh_skip_host_key_check"),
+			Debug:              c.Bool("debug"),
+			RoleARN:            c.String("role_arn_to_assume"),
+			RoleSessionName:    c.String("role_session_name"),
+			RoleExternalID:     c.String("role_external_id"),
+			RoleDuration:       c.Duration("role_duration"),
+			RoleChain:          c.StringSlice("role_chain"),
+			RoleTags:           roleTags,
+			RoleToken:          c.String("role_web_identity_token"),
+			RoleTokenFile:      c.String("role_web_identity_token_file"),
+			TerraformRootDir:   c.String("tf_root_dir"),
+			TerraformDataDir:   c.String("tf_data_dir"),
+			CleanDataDir:       c.BoolT("clean_data_dir"),
+			PluginCacheDir:     c.String("plugin_cache_dir"),
+			PlanJSON:           c.String("plan_json"),
+			Env:                env,
+			EnvFile:            c.String("env_file"),
+			MaxRetries:         c.Int("max_retries"),
+			Timeout:            c.Duration("timeout"),
 		},
 		Netrc: Netrc{
 			Login:    c.String("netrc.username"),
@@ -182,7 +498,17 @@ func run(c *cli.Context) error {
 		Terraform: Terraform{
 			Version: c.String("tf.version"),
 		},
+		Runner: execRunner{},
 	}
 
 	return plugin.Exec()
 }
+
+// optionalInt returns the value of an int flag, or nil when the flag is not set
+func optionalInt(c *cli.Context, name string) *int {
+	if !c.IsSet(name) {
+		return nil
+	}
+	value := c.Int(name)
+	return &value
+}
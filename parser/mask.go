@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SensitiveValue replaces values marked sensitive in the plan, like Terraform does
+	SensitiveValue = "(sensitive value)"
+	// RedactedValue replaces values matching a redaction pattern
+	RedactedValue = "(redacted)"
+
+	// shortValueLength is the length from which sensitive values are hidden wherever
+	// they show up
+	shortValueLength = 4
+)
+
+const (
+	// AWSAccessKeyPattern matches AWS access key IDs
+	AWSAccessKeyPattern = `\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`
+	// PrivateKeyPattern matches PEM private keys, up to the end of the output when the
+	// key was cut off
+	PrivateKeyPattern = `(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`
+	// JWTPattern matches JSON web tokens
+	JWTPattern = `\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`
+)
+
+// defaultMaskPatterns match AWS credentials, private keys and JWTs. Patterns with a
+// capture group only redact the group.
+var defaultMaskPatterns = []string{
+	AWSAccessKeyPattern,
+	`(?i)aws_?secret_?access_?key\W{1,4}([A-Za-z0-9/+=]{40})\b`,
+	PrivateKeyPattern,
+	JWTPattern,
+}
+
+type (
+	// Masker hides sensitive values and redacts the matches of patterns in the output
+	Masker struct {
+		values   []string
+		patterns []*regexp.Regexp
+	}
+)
+
+// NewMasker compiles the redaction patterns, in addition to the built-in ones
+func NewMasker(patterns []string) (*Masker, error) {
+	m := &Masker{}
+	for _, pattern := range append(defaultMaskPatterns, patterns...) {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to compile mask pattern %q. %s", pattern, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, nil
+}
+
+// AddSensitive hides the values marked sensitive by before_sensitive and after_sensitive
+// in the plan, wherever else they show up in the output
+func (m *Masker) AddSensitive(plan *Plan) {
+	seen := map[string]bool{}
+	for _, v := range m.values {
+		seen[v] = true
+	}
+
+	for _, rc := range plan.ResourceChanges {
+		var found []string
+		found = append(found, sensitiveValues(rc.Change.Before, rc.Change.BeforeSensitive, false)...)
+		found = append(found, sensitiveValues(rc.Change.After, rc.Change.AfterSensitive, false)...)
+		for _, v := range found {
+			if !seen[v] {
+				seen[v] = true
+				m.values = append(m.values, v)
+			}
+		}
+	}
+
+	// replace longer values first so values containing others are hidden whole
+	sort.Slice(m.values, func(i, j int) bool {
+		return len(m.values[i]) > len(m.values[j])
+	})
+}
+
+// Mask returns the message with the sensitive values and pattern matches replaced
+func (m *Masker) Mask(message string) string {
+	if m == nil {
+		return message
+	}
+
+	for _, v := range m.values {
+		message = strings.ReplaceAll(message, v, SensitiveValue)
+	}
+	for _, re := range m.patterns {
+		message = redact(re, message)
+	}
+
+	return message
+}
+
+// redact replaces the first capture group of every match, or the whole match for
+// patterns without groups
+func redact(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllString(s, RedactedValue)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		start, end := loc[2], loc[3]
+		if start < 0 {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(RedactedValue)
+		last = end
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// sensitiveValues returns the values marked by the sensitivity mask, which mirrors the
+// structure of the value with true for the sensitive parts
+func sensitiveValues(value, mask interface{}, sensitive bool) []string {
+	if value == nil {
+		return nil
+	}
+	if b, ok := mask.(bool); ok && b {
+		sensitive = true
+	}
+
+	switch t := value.(type) {
+	case map[string]interface{}:
+		var found []string
+		masks, _ := mask.(map[string]interface{})
+		for k, v := range t {
+			found = append(found, sensitiveValues(v, masks[k], sensitive)...)
+		}
+		return found
+	case []interface{}:
+		var found []string
+		masks, _ := mask.([]interface{})
+		for i, v := range t {
+			var elem interface{}
+			if i < len(masks) {
+				elem = masks[i]
+			}
+			found = append(found, sensitiveValues(v, elem, sensitive)...)
+		}
+		return found
+	}
+
+	if !sensitive {
+		return nil
+	}
+
+	// short values like "true" or "1" would mask unrelated parts of the output, they are
+	// only hidden at their own attribute by terraform and RenderPlan
+	switch t := value.(type) {
+	case string:
+		if len(t) < shortValueLength {
+			return nil
+		}
+		return []string{t}
+	case float64:
+		if s := strconv.FormatFloat(t, 'f', -1, 64); len(s) >= shortValueLength {
+			return []string{s}
+		}
+	}
+
+	return nil
+}
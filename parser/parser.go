@@ -12,6 +12,7 @@ type (
 	Parser struct {
 		Message string
 		Mode    string
+		Masker  *Masker
 	}
 )
 
@@ -24,7 +25,8 @@ func Parse(p *Parser) (string, error) {
 		return "", fmt.Errorf("Mode is invalid, required one of [%s]", strings.Join(modes, ","))
 	}
 
-	r := strings.NewReader(p.Message)
+	// mask the whole message, since private keys and the like span several lines
+	r := strings.NewReader(p.Masker.Mask(p.Message))
 	scanner := bufio.NewScanner(r)
 
 	for scanner.Scan() {
@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionReplace = "replace"
+	ActionRead    = "read"
+	ActionNoop    = "no-op"
+)
+
+var (
+	rInstanceKey = regexp.MustCompile(`\[[^\]]*\]`)
+	rModuleName  = regexp.MustCompile(`module\.([^.]+)`)
+)
+
+type (
+	// Plan is the subset of the `terraform show -json` document used by the plugin
+	Plan struct {
+		FormatVersion   string           `json:"format_version"`
+		ResourceChanges []ResourceChange `json:"resource_changes"`
+		Configuration   Configuration    `json:"configuration"`
+
+		// Raw is the whole document, for evaluating policies against it
+		Raw json.RawMessage `json:"-"`
+	}
+
+	// ResourceChange is a planned change to a single resource instance
+	ResourceChange struct {
+		Address       string `json:"address"`
+		ModuleAddress string `json:"module_address"`
+		Mode          string `json:"mode"`
+		Type          string `json:"type"`
+		Name          string `json:"name"`
+		Change        Change `json:"change"`
+	}
+
+	// Change holds the actions and values of a resource change
+	Change struct {
+		Actions         []string    `json:"actions"`
+		Before          interface{} `json:"before"`
+		After           interface{} `json:"after"`
+		AfterUnknown    interface{} `json:"after_unknown"`
+		BeforeSensitive interface{} `json:"before_sensitive"`
+		AfterSensitive  interface{} `json:"after_sensitive"`
+	}
+
+	// Configuration is the configuration the plan was made from
+	Configuration struct {
+		RootModule ConfigModule `json:"root_module"`
+	}
+
+	// ConfigModule holds the resources and module calls declared in a module
+	ConfigModule struct {
+		Resources   []ConfigResource      `json:"resources"`
+		ModuleCalls map[string]ModuleCall `json:"module_calls"`
+	}
+
+	// ConfigResource is a resource block declared in a module
+	ConfigResource struct {
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+	}
+
+	// ModuleCall is a module block declared in a module
+	ModuleCall struct {
+		Source string       `json:"source"`
+		Module ConfigModule `json:"module"`
+	}
+)
+
+// ParsePlan decodes the output of `terraform show -json`
+func ParsePlan(b []byte) (*Plan, error) {
+	var plan Plan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("Failed to parse plan JSON. %s", err)
+	}
+	plan.Raw = b
+
+	return &plan, nil
+}
+
+// HasChanges reports whether the plan changes any resource
+func (p *Plan) HasChanges() bool {
+	for _, rc := range p.ResourceChanges {
+		if rc.Action() != ActionNoop {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Addresses returns the addresses of the resources planned with the action, in plan order
+func (p *Plan) Addresses(action string) []string {
+	var addresses []string
+	for _, rc := range p.ResourceChanges {
+		if rc.Action() == action {
+			addresses = append(addresses, rc.Address)
+		}
+	}
+
+	return addresses
+}
+
+// Action summarises the list of change actions into a single action
+func (rc ResourceChange) Action() string {
+	actions := rc.Change.Actions
+	switch {
+	case len(actions) == 2:
+		return ActionReplace
+	case len(actions) == 1:
+		return actions[0]
+	}
+
+	return ActionNoop
+}
+
+// ModuleCallPath returns the names of the module calls leading to the resource,
+// e.g. ["network", "subnets"] for module.network.module.subnets["a"]
+func (rc ResourceChange) ModuleCallPath() []string {
+	var path []string
+	address := StripInstanceKeys(rc.ModuleAddress)
+	for _, m := range rModuleName.FindAllStringSubmatch(address, -1) {
+		path = append(path, m[1])
+	}
+
+	return path
+}
+
+// StripInstanceKeys removes the count and for_each keys of an address, e.g.
+// module.db["prod"].aws_db_instance.main[0] becomes module.db.aws_db_instance.main
+func StripInstanceKeys(address string) string {
+	return rInstanceKey.ReplaceAllString(address, "")
+}
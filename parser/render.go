@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	knownAfterApply = "(known after apply)"
+	// noChanges is what terraform show prints for a saved plan without changes, which
+	// Parse keeps in every mode
+	noChanges = "This plan does nothing.\n"
+)
+
+// RenderPlan renders the JSON plan like `terraform show` renders a saved plan, so it
+// goes through Parse the same way
+func RenderPlan(plan *Plan) string {
+	var b bytes.Buffer
+	var add, change, destroy int
+
+	for _, rc := range plan.ResourceChanges {
+		action := rc.Action()
+		switch action {
+		case ActionCreate:
+			add++
+		case ActionUpdate:
+			change++
+		case ActionDelete:
+			destroy++
+		case ActionReplace:
+			add++
+			destroy++
+		case ActionRead:
+		default:
+			continue
+		}
+
+		if b.Len() == 0 {
+			b.WriteString("Terraform will perform the following actions:\n\n")
+		}
+		renderResourceChange(&b, rc, action)
+	}
+
+	if b.Len() == 0 {
+		return noChanges
+	}
+
+	b.WriteString(fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.\n", add, change, destroy))
+
+	return b.String()
+}
+
+func renderResourceChange(b *bytes.Buffer, rc ResourceChange, action string) {
+	header := map[string]string{
+		ActionCreate:  "will be created",
+		ActionUpdate:  "will be updated in-place",
+		ActionDelete:  "will be destroyed",
+		ActionReplace: "must be replaced",
+		ActionRead:    "will be read during apply",
+	}[action]
+	symbol := map[string]string{
+		ActionCreate:  "  +",
+		ActionUpdate:  "  ~",
+		ActionDelete:  "  -",
+		ActionReplace: "-/+",
+		ActionRead:    " <=",
+	}[action]
+
+	kind := "resource"
+	if rc.Mode == "data" {
+		kind = "data"
+	}
+
+	b.WriteString(fmt.Sprintf("  # %s %s\n", rc.Address, header))
+	b.WriteString(fmt.Sprintf("%s %s %q %q {\n", symbol, kind, rc.Type, rc.Name))
+
+	before, _ := rc.Change.Before.(map[string]interface{})
+	after, _ := rc.Change.After.(map[string]interface{})
+	unknown, _ := rc.Change.AfterUnknown.(map[string]interface{})
+	beforeSensitive, _ := rc.Change.BeforeSensitive.(map[string]interface{})
+	afterSensitive, _ := rc.Change.AfterSensitive.(map[string]interface{})
+
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	for k := range unknown {
+		keys[k] = true
+	}
+
+	var names []string
+	width := 0
+	for k := range keys {
+		names = append(names, k)
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		old := renderValue(before[k], beforeSensitive[k], false)
+		newValue := renderValue(after[k], afterSensitive[k], isTrue(unknown[k]))
+		hasBefore := before[k] != nil
+		hasAfter := after[k] != nil || isTrue(unknown[k])
+		name := k + strings.Repeat(" ", width-len(k))
+
+		switch {
+		case action == ActionDelete:
+			if hasBefore {
+				b.WriteString(fmt.Sprintf("      - %s = %s -> null\n", name, old))
+			}
+		case action == ActionCreate || action == ActionRead || !hasBefore:
+			if hasAfter {
+				b.WriteString(fmt.Sprintf("      + %s = %s\n", name, newValue))
+			}
+		case !hasAfter:
+			b.WriteString(fmt.Sprintf("      - %s = %s -> null\n", name, old))
+		case old != newValue:
+			b.WriteString(fmt.Sprintf("      ~ %s = %s -> %s\n", name, old, newValue))
+		case !reflect.DeepEqual(before[k], after[k]):
+			// changed sensitive values render the same on both sides, like terraform does
+			b.WriteString(fmt.Sprintf("      ~ %s = %s\n", name, newValue))
+		}
+	}
+
+	b.WriteString("    }\n\n")
+}
+
+func isTrue(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// renderValue formats an attribute value, hiding sensitive and unknown values
+func renderValue(v interface{}, sensitive interface{}, unknown bool) string {
+	if unknown {
+		return knownAfterApply
+	}
+	if isTrue(sensitive) {
+		return SensitiveValue
+	}
+
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+
+	// nested values are rendered as JSON, and hidden whole when any part is sensitive
+	if sensitive != nil && hasSensitive(sensitive) {
+		return SensitiveValue
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(out)
+}
+
+func hasSensitive(mask interface{}) bool {
+	switch t := mask.(type) {
+	case bool:
+		return t
+	case map[string]interface{}:
+		for _, v := range t {
+			if hasSensitive(v) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			if hasSensitive(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -7,54 +7,99 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sts"
-	"github.com/google/go-github/github"
 	"github.com/robertstettner/drone-terraform-github-commenter/parser"
-	"golang.org/x/oauth2"
+	"github.com/robertstettner/drone-terraform-github-commenter/policy"
 )
 
 type (
 	// Config holds input parameters for the plugin
 	Config struct {
-		BaseURL          string
-		IssueNum         int
-		Title            string
-		Mode             string
-		Password         string
-		RepoName         string
-		RepoOwner        string
-		CommitSha        string
-		Recreate         bool
-		Username         string
-		Token            string
-		InitOptions      InitOptions
-		Cacert           string
-		Debug            bool
-		RoleARN          string
-		TerraformRootDir string
-		TerraformDataDir string
-
-		gitClient  *github.Client
-		gitContext context.Context
+		BaseURL            string
+		IssueNum           int
+		Title              string
+		Mode               string
+		Password           string
+		RepoName           string
+		RepoOwner          string
+		CommitSha          string
+		Recreate           bool
+		PreviousComments   string
+		OutputFile         string
+		CommentCache       string
+		Outputs            []string
+		Labels             bool
+		LabelPrefix        string
+		LabelColors        map[string]string
+		Stack              string
+		FailOn             []string
+		MaxDestroy         *int
+		MaxReplace         *int
+		ProtectedResources []string
+		PolicyFile         string
+		PolicyDir          string
+		MaskPatterns       []string
+		SecretAllowlist    []string
+		Username           string
+		Token              string
+		InitOptions        InitOptions
+		CACerts            []string
+		NetrcEntries       []Netrc
+		RegistryTokens     map[string]string
+		SSHKey             string
+		SSHKnownHosts      string
+		SSHSkipHostKeys    bool
+		TFCAddress         string
+		TFCToken           string
+		TFCOrganization    string
+		TFCWorkspace       string
+		TFCRunID           string
+		TFCTimeout         time.Duration
+		Debug              bool
+		RoleARN            string
+		RoleSessionName    string
+		RoleExternalID     string
+		RoleDuration       time.Duration
+		RoleChain          []string
+		RoleTags           map[string]string
+		RoleToken          string
+		RoleTokenFile      string
+		TerraformRootDir   string
+		TerraformDataDir   string
+		CleanDataDir       bool
+		PluginCacheDir     string
+		PlanJSON           string
+		Env                map[string]string
+		EnvFile            string
+		MaxRetries         int
+		Timeout            time.Duration
+
+		SCM       string
+		RemoteURL string
+
+		scm        SCM
+		scmContext context.Context
+		caBundle   []byte
 	}
 
 	// InitOptions include options for the Terraform's init command
 	InitOptions struct {
-		BackendConfig []string `json:"backend-config"`
-		Lock          *bool    `json:"lock"`
-		LockTimeout   string   `json:"lock-timeout"`
+		Backend       *bool      `json:"backend"`
+		BackendConfig stringList `json:"backend-config"`
+		FromModule    string     `json:"from-module"`
+		Lock          *bool      `json:"lock"`
+		LockTimeout   string     `json:"lock-timeout"`
+		Lockfile      string     `json:"lockfile"`
+		MigrateState  *bool      `json:"migrate-state"`
+		PluginDir     stringList `json:"plugin-dir"`
+		Reconfigure   bool       `json:"reconfigure"`
+		Upgrade       bool       `json:"upgrade"`
 	}
 
 	// Plugin represents the plugin instance to be executed
@@ -62,13 +107,16 @@ type (
 		Config    Config
 		Netrc     Netrc
 		Terraform Terraform
+		Runner    Runner
+
+		env *environment
 	}
 
 	// Netrc is credentials for cloning
 	Netrc struct {
-		Machine  string
-		Login    string
-		Password string
+		Machine  string `json:"machine"`
+		Login    string `json:"login"`
+		Password string `json:"password"`
 	}
 )
 
@@ -83,25 +131,157 @@ func (p Plugin) RunCommand(c *exec.Cmd, stdout io.Writer, stderr io.Writer) erro
 	if p.Config.TerraformRootDir != "" {
 		c.Dir = c.Dir + "/" + p.Config.TerraformRootDir
 	}
+	if c.Env == nil && p.env != nil {
+		c.Env = p.env.Environ(c.Path)
+	}
 	c.Stdout = stdout
 	c.Stderr = stderr
 	if p.Config.Debug {
 		trace(c)
 	}
 
-	return c.Run()
+	if p.Runner == nil {
+		return execRunner{}.Run(c)
+	}
+	return p.Runner.Run(c)
 }
 
 // Exec executes the plugin
 func (p Plugin) Exec() error {
 	var err error
 
-	// setup GitHub client
-	err = p.setupGitHub()
+	p.Config.caBundle, err = loadCACerts(p.Config.CACerts)
+	if err != nil {
+		return err
+	}
+
+	// setup SCM client
+	err = p.setupSCM()
+	if err != nil {
+		return err
+	}
+
+	p.env, err = p.buildEnvironment()
+	if err != nil {
+		return err
+	}
+
+	if len(p.Config.caBundle) > 0 {
+		bundle, err := writeCABundle(p.Config.caBundle)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(bundle)
+
+		// git, which fetches modules, does not read SSL_CERT_FILE, and the AWS provider
+		// reads AWS_CA_BUNDLE
+		p.env.Set("SSL_CERT_FILE", bundle)
+		p.env.Set("GIT_SSL_CAINFO", bundle)
+		p.env.Set("AWS_CA_BUNDLE", bundle)
+	}
+
+	plan, planJSON, err := p.loadPlan()
+	if err != nil {
+		return err
+	}
+
+	violations := append(p.checkProtected(planJSON), p.checkPlan(planJSON)...)
+
+	masker, err := parser.NewMasker(p.Config.MaskPatterns)
+	if err != nil {
+		return err
+	}
+	masker.AddSensitive(planJSON)
+
+	var results []policy.Result
+	if p.Config.PolicyFile != "" {
+		rules, err := policy.LoadRules(p.Config.PolicyFile)
+		if err != nil {
+			return err
+		}
+		results = rules.Evaluate(planJSON)
+	}
+	if p.Config.PolicyDir != "" {
+		policies, err := policy.LoadRego(p.Config.PolicyDir)
+		if err != nil {
+			return err
+		}
+		regoResults, err := policies.Evaluate(context.Background(), planJSON)
+		if err != nil {
+			return err
+		}
+		results = append(results, regoResults...)
+	}
+
+	msg, err := p.getPlanOutput(plan, masker, violations, results)
 	if err != nil {
 		return err
 	}
 
+	err = p.publish(msg, planJSON)
+	if err != nil {
+		return err
+	}
+
+	// fail only after publishing, so the PR explains why the build is red
+	return checksError(violations, results)
+}
+
+// loadPlan returns the plan as text and as JSON. The text is shown by terraform after
+// initializing the working directory, and rendered from the JSON of the plan_json file or
+// the Terraform Cloud run, which have no text.
+func (p Plugin) loadPlan() (string, *parser.Plan, error) {
+	if p.Config.TFCRunID != "" || p.Config.TFCWorkspace != "" {
+		return p.loadRemotePlan()
+	}
+
+	if p.Config.PlanJSON != "" {
+		b, err := ioutil.ReadFile(p.Config.PlanJSON)
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to read plan JSON file. %s", err)
+		}
+		planJSON, err := parser.ParsePlan(b)
+		if err != nil {
+			return "", nil, err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"file": p.Config.PlanJSON,
+		}).Info("Rendering the plan from the JSON file, skipping terraform init")
+
+		return parser.RenderPlan(planJSON), planJSON, nil
+	}
+
+	// writing the .netrc file with Github credentials in it, among others
+	cleanup, err := p.writeCredentials()
+	if err != nil {
+		return "", nil, err
+	}
+	defer cleanup()
+
+	err = p.initTerraform()
+	if err != nil {
+		return "", nil, err
+	}
+
+	plan, err := p.showPlan()
+	if err != nil {
+		return "", nil, err
+	}
+
+	planJSON, err := p.getPlanJSON()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return plan, planJSON, nil
+}
+
+// initTerraform installs terraform, sets up the credentials and initializes the
+// working directory, so the saved plan can be shown
+func (p Plugin) initTerraform() error {
+	var err error
+
 	// Install specified version of terraform
 	if p.Terraform.Version != "" {
 		err := installTerraform(p.Terraform.Version)
@@ -112,26 +292,30 @@ func (p Plugin) Exec() error {
 	}
 
 	if p.Config.RoleARN != "" {
-		assumeRole(p.Config.RoleARN)
+		err = p.assumeRole()
+		if err != nil {
+			return err
+		}
 	}
 
-	// writing the .netrc file with Github credentials in it.
-	err = writeNetrc(p.Netrc.Machine, p.Netrc.Login, p.Netrc.Password)
-	if err != nil {
-		return err
+	// terraform does not create the plugin cache dir
+	if p.Config.PluginCacheDir != "" {
+		if err := os.MkdirAll(p.env.terraform["TF_PLUGIN_CACHE_DIR"], 0755); err != nil {
+			return fmt.Errorf("Failed to create plugin cache dir. %s", err)
+		}
 	}
 
-	os.Setenv("TF_DATA_DIR", p.Config.TerraformDataDir)
+	if p.Config.CleanDataDir {
+		err = p.cleanDataDir()
+		if err != nil {
+			return err
+		}
+	}
 
 	var commands []*exec.Cmd
 
 	commands = append(commands, exec.Command("terraform", "version"))
 
-	if p.Config.Cacert != "" {
-		commands = append(commands, installCaCert(p.Config.Cacert))
-	}
-
-	commands = append(commands, deleteCache(p.Config.TerraformDataDir))
 	commands = append(commands, initCommand(p.Config.InitOptions))
 	commands = append(commands, getModules())
 
@@ -142,24 +326,20 @@ func (p Plugin) Exec() error {
 		}
 		err := p.RunCommand(c, stdout, os.Stderr)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("Failed to execute a command")
+			return fmt.Errorf("Failed to execute %s. %s", strings.Join(c.Args, " "), err)
 		}
 		logrus.Debug("Command completed successfully")
 	}
 
-	msg, err := p.getPlanOutput()
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	ic := &github.IssueComment{
-		Body: &msg,
-	}
+// publish posts the plan to the PR according to the configured outputs
+func (p Plugin) publish(msg string, planJSON *parser.Plan) error {
+	var err error
 
 	if p.Config.IssueNum == 0 {
-		p.Config.IssueNum, err = p.getPullRequestNumber(p.Config.gitContext)
+		p.Config.IssueNum, err = p.Config.scm.PullRequestNumber(p.Config.scmContext, p.Config.CommitSha)
 		if err != nil {
 			return err
 		}
@@ -169,110 +349,148 @@ func (p Plugin) Exec() error {
 		}
 	}
 
-	if p.Config.Recreate {
-		_, _, err = p.Config.gitClient.Issues.CreateComment(p.Config.gitContext, p.Config.RepoOwner, p.Config.RepoName, p.Config.IssueNum, ic)
-		logrus.Info("Created comment in PR")
-		return err
+	if contains(p.Config.Outputs, outputComment) {
+		err = p.postComment(msg)
+		if err != nil {
+			return err
+		}
 	}
 
-	key := generateKey(p.Config)
-	// Append plugin comment ID to comment message so we can search for it later
-	message := fmt.Sprintf("%s\n<!-- id: %s -->\n", msg, key)
-	ic.Body = &message
-	comment, err := p.Comment(key)
-	if err != nil {
-		return err
+	if contains(p.Config.Outputs, outputReview) {
+		err = p.postReview(planJSON)
+		if err != nil {
+			return err
+		}
 	}
 
-	if comment != nil {
-		_, _, err = p.Config.gitClient.Issues.EditComment(p.Config.gitContext, p.Config.RepoOwner, p.Config.RepoName, int64(*comment.ID), ic)
-		logrus.Info("Updated comment in PR")
-	} else {
-		_, _, err = p.Config.gitClient.Issues.CreateComment(p.Config.gitContext, p.Config.RepoOwner, p.Config.RepoName, p.Config.IssueNum, ic)
-		logrus.Info("Created comment in PR")
+	if p.Config.Labels {
+		err = p.applyLabels(planJSON)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (p *Plugin) setupGitHub() error {
-	err := p.validate()
+// postComment creates or updates the plan comment in the PR
+func (p Plugin) postComment(msg string) error {
+	key := generateKey(p.Config)
+	// Append plugin comment ID to comment message so we can search for it later
+	message := fmt.Sprintf("%s\n%s\n", msg, commentMarker(key))
+
+	if p.Config.Recreate {
+		created, err := p.createComment(message)
+		if err != nil {
+			return err
+		}
+		p.cacheComment(key, created)
+
+		if err := p.cleanupPreviousComments(key, created.ID); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("Failed to clean up previous comments in PR")
+		}
+		return nil
+	}
 
+	comment, err := p.Comment(key)
 	if err != nil {
 		return err
 	}
 
-	err = p.initGitClient()
-
+	if comment != nil {
+		comment, err = p.editComment(comment.ID, message)
+	} else {
+		comment, err = p.createComment(message)
+	}
 	if err != nil {
 		return err
 	}
+	p.cacheComment(key, comment)
 
 	return nil
 }
 
-// Comment returns existing comment, nil if none exist
-func (p Plugin) Comment(key string) (*github.IssueComment, error) {
-	comments, err := p.allIssueComments(p.Config.gitContext)
+// postReview posts the destroyed and replaced resources as a PR review
+func (p Plugin) postReview(plan *parser.Plan) error {
+	r, ok := p.Config.scm.(reviewPoster)
+	if !ok {
+		return fmt.Errorf("Review output is not supported by %s", p.Config.SCM)
+	}
 
+	workspace, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return filterComment(comments, key), nil
+	return r.PostReview(p.Config.scmContext, p.Config.IssueNum, Review{
+		Key:              generateKey(p.Config),
+		CommitSha:        p.Config.CommitSha,
+		Title:            p.Config.Title,
+		Workspace:        workspace,
+		RootDir:          p.Config.TerraformRootDir,
+		PreviousComments: p.Config.PreviousComments,
+		Plan:             plan,
+	})
 }
 
-func (p Plugin) allIssueComments(ctx context.Context) ([]*github.IssueComment, error) {
-	if p.Config.gitClient == nil {
-		return nil, fmt.Errorf("allIssueComments(): git client not initialized")
+// cacheComment saves the comment identity so the next build can find it without a full scan
+func (p Plugin) cacheComment(key string, comment *Comment) {
+	if err := writeCommentCache(p.Config.CommentCache, key, comment); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Failed to write comment cache")
 	}
+}
 
-	opts := &github.IssueListCommentsOptions{}
+func (p *Plugin) setupSCM() error {
+	var err error
 
-	// get all pages of results
-	var allComments []*github.IssueComment
-	for {
-		comments, resp, err := p.Config.gitClient.Issues.ListComments(ctx, p.Config.RepoOwner, p.Config.RepoName, p.Config.IssueNum, opts)
-		if err != nil {
-			return nil, err
-		}
-		allComments = append(allComments, comments...)
-		if resp.NextPage == 0 {
-			break
+	if p.Config.SCM == "" {
+		p.Config.SCM = detectSCM(p.Config.RemoteURL)
+		if p.Config.BaseURL == "" || p.Config.BaseURL == defaultGitHubURL {
+			if base := detectBaseURL(p.Config.SCM, p.Config.RemoteURL); base != "" {
+				p.Config.BaseURL = base
+			}
 		}
-		opts.Page = resp.NextPage
+	}
+	p.Config.SCM, err = scmName(p.Config.SCM)
+	if err != nil {
+		return err
 	}
 
-	return allComments, nil
-}
+	if p.Config.Token == "" {
+		p.Config.Token = scmToken(p.Config.SCM, os.Getenv)
+	}
+	// fall back to the netrc credentials, which Drone provides for every SCM
+	if p.Config.Token == "" && p.Config.Username == "" && p.Config.Password == "" {
+		p.Config.Username = p.Netrc.Login
+		p.Config.Password = p.Netrc.Password
+	}
 
-func (p *Plugin) initGitClient() error {
-	if !strings.HasSuffix(p.Config.BaseURL, "/") {
-		p.Config.BaseURL = p.Config.BaseURL + "/"
+	err = p.validate()
+	if err != nil {
+		return err
 	}
 
-	baseURL, err := url.Parse(p.Config.BaseURL)
+	p.Config.scmContext = context.Background()
+	p.Config.scm, err = newSCM(p.Config)
 
 	if err != nil {
-		return fmt.Errorf("Failed to parse base URL. %s", err)
+		return err
 	}
 
-	p.Config.gitContext = context.Background()
+	return nil
+}
 
-	if p.Config.Token != "" {
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: p.Config.Token})
-		tc := oauth2.NewClient(p.Config.gitContext, ts)
-		p.Config.gitClient = github.NewClient(tc)
-	} else {
-		tp := github.BasicAuthTransport{
-			Username: strings.TrimSpace(p.Config.Username),
-			Password: strings.TrimSpace(p.Config.Password),
-		}
-		p.Config.gitClient = github.NewClient(tp.Client())
+// Comment returns existing comment, nil if none exist
+func (p Plugin) Comment(key string) (*Comment, error) {
+	if p.Config.scm == nil {
+		return nil, fmt.Errorf("Comment(): scm client not initialized")
 	}
-	p.Config.gitClient.BaseURL = baseURL
 
-	return nil
+	return p.Config.scm.FindComment(p.Config.scmContext, p.Config.IssueNum, key)
 }
 
 func generateKey(config Config) string {
@@ -281,38 +499,35 @@ func generateKey(config Config) string {
 	return fmt.Sprintf("%x", hash)
 }
 
-func filterComment(comments []*github.IssueComment, key string) *github.IssueComment {
-	for _, comment := range comments {
-		if strings.Contains(*comment.Body, fmt.Sprintf("<!-- id: %s -->", key)) {
-			return comment
-		}
-	}
-
-	return nil
-}
-
 func (p Plugin) validate() error {
 	if p.Config.Token == "" && (p.Config.Username == "" || p.Config.Password == "") {
 		return fmt.Errorf("You must provide an API key or Username and Password")
 	}
 
-	return nil
-}
+	for _, output := range p.Config.Outputs {
+		if !contains(outputs, output) {
+			return fmt.Errorf("Output is invalid, required any of [%s]", strings.Join(outputs, ","))
+		}
+	}
 
-func (p Plugin) getPullRequestNumber(ctx context.Context) (int, error) {
-	res, _, err := p.Config.gitClient.Search.Issues(ctx, fmt.Sprintf("%s repo:%s/%s", p.Config.CommitSha, p.Config.RepoOwner, p.Config.RepoName), nil)
-	if err != nil {
-		return 0, err
+	for _, action := range p.Config.FailOn {
+		if !contains(failOnActions, action) {
+			return fmt.Errorf("Fail on is invalid, required any of [%s]", strings.Join(failOnActions, ","))
+		}
+	}
+
+	if (p.Config.MaxDestroy != nil && *p.Config.MaxDestroy < 0) || (p.Config.MaxReplace != nil && *p.Config.MaxReplace < 0) {
+		return fmt.Errorf("Max destroy and max replace must not be negative")
 	}
-	if len(res.Issues) == 0 {
-		return 0, nil
+
+	if p.Config.PreviousComments != "" && !contains(previousCommentsModes, p.Config.PreviousComments) {
+		return fmt.Errorf("Previous comments mode is invalid, required one of [%s]", strings.Join(previousCommentsModes, ","))
 	}
 
-	return *res.Issues[0].Number, nil
+	return nil
 }
 
-func getTfoutPath() string {
-	terraformDataDir := os.Getenv("TF_DATA_DIR")
+func getTfoutPath(terraformDataDir string) string {
 	if terraformDataDir == ".terraform" || terraformDataDir == "" {
 		return "plan.tfout"
 	}
@@ -320,10 +535,27 @@ func getTfoutPath() string {
 	return fmt.Sprintf("%s.plan.tfout", terraformDataDir)
 }
 
-func (p Plugin) getPlanOutput() (string, error) {
+func (p Plugin) getPlanJSON() (*parser.Plan, error) {
+	var out bytes.Buffer
+
+	c := exec.Command(
+		"terraform",
+		"show",
+		"-json",
+		getTfoutPath(p.Config.TerraformDataDir),
+	)
+	err := p.RunCommand(c, &out, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.ParsePlan(out.Bytes())
+}
+
+func (p Plugin) showPlan() (string, error) {
 	var out bytes.Buffer
 
-	file := getTfoutPath()
+	file := getTfoutPath(p.Config.TerraformDataDir)
 
 	c := exec.Command(
 		"terraform",
@@ -336,49 +568,25 @@ func (p Plugin) getPlanOutput() (string, error) {
 		return "", err
 	}
 
+	return out.String(), nil
+}
+
+func (p Plugin) getPlanOutput(plan string, masker *parser.Masker, violations []string, results []policy.Result) (string, error) {
 	opts := &parser.Parser{
-		Message: out.String(),
+		Message: plan,
 		Mode:    p.Config.Mode,
+		Masker:  masker,
 	}
 	msg, err := parser.Parse(opts)
 	if err != nil {
 		return "", err
 	}
 
-	message := fmt.Sprintf("## %s\n\n```diff\n%s```\n", p.Config.Title, msg)
+	message := fmt.Sprintf("## %s\n\n%s%s```diff\n%s```\n", p.Config.Title, renderViolations(violations), masker.Mask(renderPolicyResults(results)), msg)
 
 	return message, nil
 }
 
-func assumeRole(roleArn string) {
-	client := sts.New(session.New())
-	duration := time.Hour * 1
-	stsProvider := &stscreds.AssumeRoleProvider{
-		Client:          client,
-		Duration:        duration,
-		RoleARN:         roleArn,
-		RoleSessionName: "drone",
-	}
-
-	value, err := credentials.NewCredentials(stsProvider).Get()
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Error assuming role!")
-	}
-	os.Setenv("AWS_ACCESS_KEY_ID", value.AccessKeyID)
-	os.Setenv("AWS_SECRET_ACCESS_KEY", value.SecretAccessKey)
-	os.Setenv("AWS_SESSION_TOKEN", value.SessionToken)
-}
-
-func deleteCache(terraformDataDir string) *exec.Cmd {
-	return exec.Command(
-		"rm",
-		"-rf",
-		terraformDataDir,
-	)
-}
-
 func getModules() *exec.Cmd {
 	return exec.Command(
 		"terraform",
@@ -391,10 +599,19 @@ func initCommand(config InitOptions) *exec.Cmd {
 		"init",
 	}
 
+	// True is default in TF, false skips the backend, which showing a saved plan does not need
+	if config.Backend != nil {
+		args = append(args, fmt.Sprintf("-backend=%t", *config.Backend))
+	}
+
 	for _, v := range config.BackendConfig {
 		args = append(args, fmt.Sprintf("-backend-config=%s", v))
 	}
 
+	if config.FromModule != "" {
+		args = append(args, fmt.Sprintf("-from-module=%s", config.FromModule))
+	}
+
 	// True is default in TF
 	if config.Lock != nil {
 		args = append(args, fmt.Sprintf("-lock=%t", *config.Lock))
@@ -405,6 +622,26 @@ func initCommand(config InitOptions) *exec.Cmd {
 		args = append(args, fmt.Sprintf("-lock-timeout=%s", config.LockTimeout))
 	}
 
+	if config.Lockfile != "" {
+		args = append(args, fmt.Sprintf("-lockfile=%s", config.Lockfile))
+	}
+
+	if config.MigrateState != nil {
+		args = append(args, fmt.Sprintf("-migrate-state=%t", *config.MigrateState))
+	}
+
+	for _, v := range config.PluginDir {
+		args = append(args, fmt.Sprintf("-plugin-dir=%s", v))
+	}
+
+	if config.Reconfigure {
+		args = append(args, "-reconfigure")
+	}
+
+	if config.Upgrade {
+		args = append(args, "-upgrade")
+	}
+
 	// Fail Terraform execution on prompt
 	args = append(args, "-input=false")
 
@@ -414,11 +651,13 @@ func initCommand(config InitOptions) *exec.Cmd {
 	)
 }
 
-func installCaCert(cacert string) *exec.Cmd {
-	ioutil.WriteFile("/usr/local/share/ca-certificates/ca_cert.crt", []byte(cacert), 0644)
-	return exec.Command(
-		"update-ca-certificates",
-	)
+func contains(arr []string, str string) bool {
+	for _, a := range arr {
+		if a == str {
+			return true
+		}
+	}
+	return false
 }
 
 func trace(cmd *exec.Cmd) {
@@ -428,24 +667,34 @@ func trace(cmd *exec.Cmd) {
 // helper function to write a netrc file.
 // The following code comes from the official Git plugin for Drone:
 // https://github.com/drone-plugins/drone-git/blob/8386effd2fe8c8695cf979427f8e1762bd805192/utils.go#L43-L68
-func writeNetrc(machine, login, password string) error {
-	if machine == "" {
+func writeNetrc(path string, entries []Netrc) error {
+	var out bytes.Buffer
+	for _, e := range entries {
+		if e.Machine == "" {
+			continue
+		}
+		out.WriteString(fmt.Sprintf(
+			netrcFile,
+			e.Machine,
+			e.Login,
+			e.Password,
+		))
+	}
+	if out.Len() == 0 {
 		return nil
 	}
-	out := fmt.Sprintf(
-		netrcFile,
-		machine,
-		login,
-		password,
-	)
 
+	return ioutil.WriteFile(path, out.Bytes(), 0600)
+}
+
+func homeDir() string {
 	home := "/root"
 	u, err := user.Current()
 	if err == nil {
 		home = u.HomeDir
 	}
-	path := filepath.Join(home, ".netrc")
-	return ioutil.WriteFile(path, []byte(out), 0600)
+
+	return home
 }
 
 const netrcFile = `
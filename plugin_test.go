@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/google/go-github/github"
+)
+
+const (
+	execPlanText = `
+Terraform will perform the following actions:
+
+  # aws_s3_bucket.logs will be destroyed
+  - resource "aws_s3_bucket" "logs" {
+      - bucket = "logs"
+    }
+
+  # aws_instance.web will be created
+  + resource "aws_instance" "web" {
+      + ami = "ami-123456"
+    }
+
+Plan: 1 to add, 0 to change, 1 to destroy.
+`
+
+	execPlanJSON = `{
+  "format_version": "0.1",
+  "resource_changes": [
+    {"address": "aws_s3_bucket.logs", "type": "aws_s3_bucket", "name": "logs", "change": {"actions": ["delete"], "before": {"bucket": "logs"}, "after": null}},
+    {"address": "aws_instance.web", "type": "aws_instance", "name": "web", "change": {"actions": ["create"], "before": null, "after": {"ami": "ami-123456"}}}
+  ]
+}`
+)
+
+// fakeRunner records the commands instead of running them, writing canned output for
+// the commands whose arguments match
+type fakeRunner struct {
+	outputs  map[string]string
+	failures map[string]error
+	commands []*exec.Cmd
+}
+
+func (f *fakeRunner) Run(c *exec.Cmd) error {
+	f.commands = append(f.commands, c)
+
+	args := strings.Join(c.Args, " ")
+	if err, ok := f.failures[args]; ok {
+		return err
+	}
+	if out, ok := f.outputs[args]; ok {
+		io.WriteString(c.Stdout, out)
+	}
+
+	return nil
+}
+
+func (f *fakeRunner) commandLines() []string {
+	var lines []string
+	for _, c := range f.commands {
+		lines = append(lines, strings.Join(c.Args, " "))
+	}
+
+	return lines
+}
+
+// fakeGitHub is an in-memory stand-in for the parts of the GitHub API used to comment
+type fakeGitHub struct {
+	comments  []*github.IssueComment
+	nextID    int64
+	login     string
+	minimized []string
+	requests  []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+	find := func() *github.IssueComment {
+		id, _ := strconv.ParseInt(parts[5], 10, 64)
+		for _, comment := range f.comments {
+			if comment.GetID() == id {
+				return comment
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case r.URL.Path == "/search/issues":
+		write(map[string]interface{}{
+			"total_count": 1,
+			"items":       []map[string]interface{}{{"number": 7}},
+		})
+	case r.URL.Path == "/user":
+		if f.login == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		write(map[string]string{"login": f.login})
+	case r.URL.Path == "/graphql" && r.Method == "POST":
+		var req graphQLRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Query != minimizeCommentMutation {
+			write(map[string]interface{}{"errors": []map[string]string{{"message": "unknown query"}}})
+			return
+		}
+		f.minimized = append(f.minimized, req.Variables["id"].(string))
+		write(map[string]interface{}{"data": map[string]interface{}{}})
+	case r.URL.Path == "/repos/owner/repo/issues/7/comments" && r.Method == "GET":
+		f.listComments(w, r)
+	case r.URL.Path == "/repos/owner/repo/issues/7/comments" && r.Method == "POST":
+		var comment github.IssueComment
+		json.NewDecoder(r.Body).Decode(&comment)
+		f.add(comment.GetBody(), "drone")
+		w.WriteHeader(http.StatusCreated)
+		write(f.comments[len(f.comments)-1])
+	case len(parts) == 6 && parts[4] == "comments":
+		comment := find()
+		if comment == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			// the node ID is missing from the go-github comment
+			write(map[string]interface{}{"id": comment.GetID(), "node_id": fmt.Sprintf("IC_%d", comment.GetID())})
+		case "PATCH":
+			var edit github.IssueComment
+			json.NewDecoder(r.Body).Decode(&edit)
+			updated := f.tick()
+			comment.Body = edit.Body
+			comment.UpdatedAt = &updated
+			write(comment)
+		case "DELETE":
+			for i, c := range f.comments {
+				if c == comment {
+					f.comments = append(f.comments[:i], f.comments[i+1:]...)
+					break
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// add appends a comment by the user, as if it was posted to the PR
+func (f *fakeGitHub) add(body, login string) *github.IssueComment {
+	f.nextID++
+	updated := f.tick()
+	comment := &github.IssueComment{
+		ID:        github.Int64(f.nextID),
+		HTMLURL:   github.String(fmt.Sprintf("https://github.com/owner/repo/pull/7#issuecomment-%d", f.nextID)),
+		Body:      github.String(body),
+		User:      &github.User{Login: github.String(login)},
+		UpdatedAt: &updated,
+	}
+	f.comments = append(f.comments, comment)
+
+	return comment
+}
+
+// tick returns a new update time for every change, so since filters are deterministic
+func (f *fakeGitHub) tick() time.Time {
+	return time.Unix(1600000000, 0).Add(time.Duration(len(f.requests)) * time.Minute).UTC()
+}
+
+// listComments serves the comments oldest-first, filtered by since and paginated with
+// Link headers like GitHub
+func (f *fakeGitHub) listComments(w http.ResponseWriter, r *http.Request) {
+	var comments []*github.IssueComment
+	since, _ := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	for _, comment := range f.comments {
+		if !comment.GetUpdatedAt().Before(since) {
+			comments = append(comments, comment)
+		}
+	}
+
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage == 0 {
+		perPage = 30
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+	last := (len(comments) + perPage - 1) / perPage
+	if last == 0 {
+		last = 1
+	}
+
+	link := func(page int, rel string) string {
+		return fmt.Sprintf(`<http://%s%s?page=%d&per_page=%d>; rel="%s"`, r.Host, r.URL.Path, page, perPage, rel)
+	}
+	if page < last {
+		w.Header().Set("Link", link(page+1, "next")+", "+link(last, "last"))
+	}
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if start > len(comments) {
+		start = len(comments)
+	}
+	if end > len(comments) {
+		end = len(comments)
+	}
+	json.NewEncoder(w).Encode(comments[start:end])
+}
+
+func TestExec(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Exec", func() {
+		var gh *fakeGitHub
+		var server *httptest.Server
+		var runner *fakeRunner
+		var plugin Plugin
+
+		g.BeforeEach(func() {
+			gh = &fakeGitHub{login: "drone"}
+			server = httptest.NewServer(gh)
+			runner = &fakeRunner{
+				outputs: map[string]string{
+					"terraform show -no-color plan.tfout": execPlanText,
+					"terraform show -json plan.tfout":     execPlanJSON,
+				},
+			}
+			plugin = Plugin{
+				Config: Config{
+					BaseURL:          server.URL + "/",
+					Token:            "secret",
+					SCM:              scmGitHub,
+					RepoOwner:        "owner",
+					RepoName:         "repo",
+					CommitSha:        "abc123",
+					Title:            "Terraform Plan Output",
+					Mode:             "simple",
+					Outputs:          []string{outputComment},
+					TerraformDataDir: ".terraform",
+					Env:              map[string]string{"TF_VAR_env": "prod"},
+				},
+				Runner: runner,
+			}
+		})
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("runs terraform and comments the plan", func() {
+			err := plugin.Exec()
+			g.Assert(err == nil).IsTrue("should not error")
+
+			g.Assert(runner.commandLines()).Equal([]string{
+				"terraform version",
+				"terraform init -input=false",
+				"terraform get",
+				"terraform show -no-color plan.tfout",
+				"terraform show -json plan.tfout",
+			})
+
+			g.Assert(len(gh.comments)).Equal(1)
+			body := gh.comments[0].GetBody()
+			g.Assert(strings.HasPrefix(body, "## Terraform Plan Output\n\n```diff\n# aws_s3_bucket.logs will be destroyed\n# aws_instance.web will be created\n\nPlan: 1 to add, 0 to change, 1 to destroy.\n```\n")).IsTrue("should comment the simple plan")
+			g.Assert(strings.Contains(body, commentMarker(generateKey(Config{RepoOwner: "owner", RepoName: "repo", Title: "Terraform Plan Output", IssueNum: 7})))).IsTrue("should mark the comment")
+		})
+
+		g.It("passes the terraform environment only to terraform", func() {
+			plugin.Exec()
+
+			for _, c := range runner.commands {
+				env := strings.Join(c.Env, "\n")
+				isTerraform := c.Args[0] == "terraform"
+				g.Assert(strings.Contains(env, "TF_VAR_env=prod")).Equal(isTerraform)
+				g.Assert(strings.Contains(env, "TF_DATA_DIR=.terraform")).Equal(isTerraform)
+			}
+		})
+
+		g.It("updates the comment of the previous build", func() {
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+			runner.outputs["terraform show -no-color plan.tfout"] = "  # aws_instance.web will be created\n\nPlan: 1 to add, 0 to change, 0 to destroy.\n"
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+
+			g.Assert(len(gh.comments)).Equal(1)
+			g.Assert(strings.Contains(gh.comments[0].GetBody(), "Plan: 1 to add, 0 to change, 0 to destroy.")).IsTrue("should update the comment")
+		})
+
+		g.It("writes the created and updated comment to the output file", func() {
+			dir, _ := ioutil.TempDir("", "output")
+			defer os.RemoveAll(dir)
+			plugin.Config.OutputFile = filepath.Join(dir, "out", "comment.json")
+
+			read := func() map[string]interface{} {
+				var result map[string]interface{}
+				b, _ := ioutil.ReadFile(plugin.Config.OutputFile)
+				g.Assert(json.Unmarshal(b, &result) == nil).IsTrue("should write JSON")
+				return result
+			}
+
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+			g.Assert(read()).Equal(map[string]interface{}{
+				"action":       "created",
+				"issue_number": float64(7),
+				"comment_id":   float64(1),
+				"comment_url":  "https://github.com/owner/repo/pull/7#issuecomment-1",
+			})
+
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+			g.Assert(read()["action"]).Equal("updated")
+			g.Assert(read()["comment_id"]).Equal(float64(1))
+		})
+
+		g.It("fails the build after commenting", func() {
+			plugin.Config.FailOn = []string{failOnDestroy}
+			err := plugin.Exec()
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(err.Error()).Equal("Plan failed 1 check(s): aws_s3_bucket.logs will be destroyed")
+
+			g.Assert(len(gh.comments)).Equal(1)
+			g.Assert(strings.Contains(gh.comments[0].GetBody(), "- aws_s3_bucket.logs will be destroyed")).IsTrue("should explain the failure")
+		})
+
+		g.It("returns the error of failing commands", func() {
+			runner.failures = map[string]error{
+				"terraform init -input=false": errors.New("exit status 1"),
+			}
+			err := plugin.Exec()
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(err.Error()).Equal("Failed to execute terraform init -input=false. exit status 1")
+			g.Assert(len(gh.comments)).Equal(0)
+		})
+
+		g.It("renders the plan JSON file without running terraform", func() {
+			file, _ := ioutil.TempFile("", "plan.json")
+			defer os.Remove(file.Name())
+			file.WriteString(execPlanJSON)
+			file.Close()
+
+			plugin.Config.PlanJSON = file.Name()
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+
+			g.Assert(len(runner.commands)).Equal(0)
+			g.Assert(len(gh.comments)).Equal(1)
+			g.Assert(strings.HasPrefix(gh.comments[0].GetBody(), "## Terraform Plan Output\n\n```diff\n# aws_s3_bucket.logs will be destroyed\n# aws_instance.web will be created\n\nPlan: 1 to add, 0 to change, 1 to destroy.\n```\n")).IsTrue("should comment the rendered plan")
+		})
+
+		g.It("renders the plan of the Terraform Cloud run of the commit", func() {
+			tfc := httptest.NewServer(&fakeTFC{})
+			defer tfc.Close()
+
+			plugin.Config.TFCAddress = tfc.URL
+			plugin.Config.TFCToken = "tfc-token"
+			plugin.Config.TFCOrganization = "acme"
+			plugin.Config.TFCWorkspace = "prod"
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+
+			g.Assert(len(runner.commands)).Equal(0)
+			g.Assert(len(gh.comments)).Equal(1)
+			g.Assert(strings.HasPrefix(gh.comments[0].GetBody(), "## Terraform Plan Output\n\n```diff\n# aws_s3_bucket.logs will be destroyed\n# aws_instance.web will be created\n\nPlan: 1 to add, 0 to change, 1 to destroy.\n```\n")).IsTrue("should comment the rendered plan")
+		})
+
+		g.It("inits without the backend and with a plugin cache dir", func() {
+			dir, _ := ioutil.TempDir("", "plugins")
+			defer os.RemoveAll(dir)
+
+			backend := false
+			plugin.Config.InitOptions.Backend = &backend
+			plugin.Config.PluginCacheDir = filepath.Join(dir, "cache")
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+
+			g.Assert(runner.commandLines()[1]).Equal("terraform init -backend=false -input=false")
+			g.Assert(strings.Contains(strings.Join(runner.commands[1].Env, "\n"), "TF_PLUGIN_CACHE_DIR="+filepath.Join(dir, "cache"))).IsTrue("should pass the cache dir")
+			_, err := os.Stat(filepath.Join(dir, "cache"))
+			g.Assert(err == nil).IsTrue("should create the cache dir")
+		})
+
+		g.It("trusts the CA certs for the SCM API and terraform", func() {
+			server.Close()
+			server = httptest.NewTLSServer(gh)
+			plugin.Config.BaseURL = server.URL + "/"
+			plugin.Config.CACerts = []string{serverCertPEM(server)}
+
+			g.Assert(plugin.Exec() == nil).IsTrue("should not error")
+			g.Assert(len(gh.comments)).Equal(1)
+			env := strings.Join(runner.commands[0].Env, "\n")
+			g.Assert(strings.Contains(env, "SSL_CERT_FILE=")).IsTrue("should pass the CA bundle to terraform")
+			g.Assert(strings.Contains(env, "AWS_CA_BUNDLE=")).IsTrue("should pass the CA bundle to the AWS provider")
+		})
+	})
+}
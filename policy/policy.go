@@ -0,0 +1,294 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SeverityWarn = "warn"
+	SeverityDeny = "deny"
+)
+
+var (
+	severities = []string{SeverityWarn, SeverityDeny}
+	operators  = []string{"==", "!=", "contains", "not_contains", "matches", "exists", "not_exists"}
+)
+
+type (
+	// Rules is a set of declarative rules evaluated against the JSON plan
+	Rules struct {
+		Rules []Rule `yaml:"rules"`
+	}
+
+	// Rule matches resource changes by type, address and action, and attribute conditions
+	Rule struct {
+		Name        string   `yaml:"name"`
+		Description string   `yaml:"description"`
+		Severity    string   `yaml:"severity"`
+		Type        string   `yaml:"type"`
+		Address     string   `yaml:"address"`
+		Actions     []string `yaml:"actions"`
+		When        []string `yaml:"when"`
+
+		conditions []condition
+	}
+
+	// Result is a rule, or policy, matching a resource change
+	Result struct {
+		Rule     string
+		Severity string
+		Address  string
+		Message  string
+	}
+
+	// condition is a parsed `<attribute> <operator> [value]` expression
+	condition struct {
+		Attribute []string
+		Operator  string
+		Value     string
+		regexp    *regexp.Regexp
+	}
+)
+
+// LoadRules reads and validates a YAML rule file
+func LoadRules(file string) (*Rules, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read rule file. %s", err)
+	}
+
+	return ParseRules(b)
+}
+
+// ParseRules decodes and validates YAML rules
+func ParseRules(b []byte) (*Rules, error) {
+	var rules Rules
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("Failed to parse rules. %s", err)
+	}
+
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("Rule #%d has no name", i+1)
+		}
+		if r.Severity == "" {
+			r.Severity = SeverityDeny
+		}
+		if !contains(severities, r.Severity) {
+			return nil, fmt.Errorf("Rule %s has an invalid severity, required one of [%s]", r.Name, strings.Join(severities, ","))
+		}
+		for _, expr := range r.When {
+			c, err := parseCondition(expr)
+			if err != nil {
+				return nil, fmt.Errorf("Rule %s has an invalid condition. %s", r.Name, err)
+			}
+			r.conditions = append(r.conditions, c)
+		}
+	}
+
+	return &rules, nil
+}
+
+// Evaluate returns a result for every rule matching a resource change of the plan
+func (rs *Rules) Evaluate(plan *parser.Plan) []Result {
+	var results []Result
+
+	for _, rc := range plan.ResourceChanges {
+		action := rc.Action()
+		if action == parser.ActionNoop {
+			continue
+		}
+
+		for _, r := range rs.Rules {
+			if !r.matches(rc, action) {
+				continue
+			}
+
+			message := r.Description
+			if message == "" {
+				message = fmt.Sprintf("%s matches rule %s", rc.Address, r.Name)
+			}
+			results = append(results, Result{
+				Rule:     r.Name,
+				Severity: r.Severity,
+				Address:  rc.Address,
+				Message:  message,
+			})
+		}
+	}
+
+	return results
+}
+
+func (r Rule) matches(rc parser.ResourceChange, action string) bool {
+	if r.Type != "" && !glob(r.Type, rc.Type) {
+		return false
+	}
+	if r.Address != "" && !glob(r.Address, rc.Address) {
+		return false
+	}
+	if len(r.Actions) > 0 && !contains(r.Actions, action) {
+		return false
+	}
+
+	// conditions look at the values after the change, or before it for destroys
+	values := rc.Change.After
+	if action == parser.ActionDelete {
+		values = rc.Change.Before
+	}
+	for _, c := range r.conditions {
+		if !c.matches(values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseCondition(expr string) (condition, error) {
+	fields := strings.Fields(expr)
+	if len(fields) < 2 {
+		return condition{}, fmt.Errorf("%q is not `<attribute> <operator> [value]`", expr)
+	}
+
+	c := condition{
+		Attribute: strings.Split(fields[0], "."),
+		Operator:  fields[1],
+		Value:     strings.Trim(strings.Join(fields[2:], " "), `"'`),
+	}
+	if !contains(operators, c.Operator) {
+		return condition{}, fmt.Errorf("%q has an invalid operator, required one of [%s]", expr, strings.Join(operators, ","))
+	}
+	if c.Operator == "matches" {
+		re, err := regexp.Compile(c.Value)
+		if err != nil {
+			return condition{}, err
+		}
+		c.regexp = re
+	}
+
+	return c, nil
+}
+
+func (c condition) matches(values interface{}) bool {
+	found := lookup(values, c.Attribute)
+
+	switch c.Operator {
+	case "exists":
+		return len(found) > 0
+	case "not_exists":
+		return len(found) == 0
+	case "not_contains":
+		return !anyValue(found, c.contains)
+	case "!=":
+		return !anyValue(found, c.equals)
+	case "contains":
+		return anyValue(found, c.contains)
+	case "matches":
+		return anyValue(found, func(v interface{}) bool { return c.regexp.MatchString(scalar(v)) })
+	default:
+		return anyValue(found, c.equals)
+	}
+}
+
+func (c condition) equals(v interface{}) bool {
+	return scalar(v) == c.Value
+}
+
+// contains checks list elements for equality and strings for substrings
+func (c condition) contains(v interface{}) bool {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, e := range t {
+			if scalar(e) == c.Value {
+				return true
+			}
+		}
+		return false
+	case string:
+		return strings.Contains(t, c.Value)
+	}
+
+	return false
+}
+
+// lookup walks the attribute path, fanning out over lists of objects, and returns all
+// values found at the end of the path
+func lookup(value interface{}, attribute []string) []interface{} {
+	if value == nil {
+		return nil
+	}
+	if len(attribute) == 0 {
+		return []interface{}{value}
+	}
+
+	key := attribute[0]
+	switch t := value.(type) {
+	case map[string]interface{}:
+		v, ok := t[key]
+		if !ok {
+			return nil
+		}
+		return lookup(v, attribute[1:])
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil {
+			if i < 0 || i >= len(t) {
+				return nil
+			}
+			return lookup(t[i], attribute[1:])
+		}
+		var found []interface{}
+		for _, e := range t {
+			found = append(found, lookup(e, attribute)...)
+		}
+		return found
+	}
+
+	return nil
+}
+
+func anyValue(values []interface{}, fn func(interface{}) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scalar(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func glob(pattern, s string) bool {
+	matched, _ := path.Match(pattern, s)
+	return matched
+}
+
+func contains(arr []string, str string) bool {
+	for _, a := range arr {
+		if a == str {
+			return true
+		}
+	}
+	return false
+}
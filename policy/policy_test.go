@@ -0,0 +1,137 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+const planJSON = `{
+  "format_version": "0.1",
+  "resource_changes": [
+    {
+      "address": "aws_security_group_rule.open",
+      "type": "aws_security_group_rule",
+      "name": "open",
+      "change": {
+        "actions": ["create"],
+        "before": null,
+        "after": {"type": "ingress", "from_port": 22, "cidr_blocks": ["10.0.0.0/8", "0.0.0.0/0"]}
+      }
+    },
+    {
+      "address": "aws_security_group_rule.internal",
+      "type": "aws_security_group_rule",
+      "name": "internal",
+      "change": {
+        "actions": ["update"],
+        "before": {"cidr_blocks": ["0.0.0.0/0"]},
+        "after": {"type": "ingress", "from_port": 443, "cidr_blocks": ["10.0.0.0/8"]}
+      }
+    },
+    {
+      "address": "module.db.aws_db_instance.main",
+      "type": "aws_db_instance",
+      "name": "main",
+      "change": {
+        "actions": ["delete"],
+        "before": {"tags": {"env": "prod"}, "ingress": [{"port": 5432}]},
+        "after": null
+      }
+    },
+    {
+      "address": "aws_s3_bucket.logs",
+      "type": "aws_s3_bucket",
+      "name": "logs",
+      "change": {
+        "actions": ["no-op"],
+        "before": {"acl": "public-read"},
+        "after": {"acl": "public-read"}
+      }
+    }
+  ]
+}`
+
+func TestRules(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Rules", func() {
+		plan, err := parser.ParsePlan([]byte(planJSON))
+		if err != nil {
+			g.Fail("Cannot parse the plan")
+		}
+
+		evaluate := func(yml string) []Result {
+			rules, err := ParseRules([]byte(yml))
+			g.Assert(err == nil).IsTrue("should parse the rules")
+			return rules.Evaluate(plan)
+		}
+
+		g.It("matches list attributes with contains", func() {
+			results := evaluate(`
+rules:
+  - name: no-open-ingress
+    description: Ingress must not be open to the internet
+    type: aws_security_group_rule
+    when:
+      - cidr_blocks contains 0.0.0.0/0
+`)
+			g.Assert(results).Equal([]Result{{
+				Rule:     "no-open-ingress",
+				Severity: SeverityDeny,
+				Address:  "aws_security_group_rule.open",
+				Message:  "Ingress must not be open to the internet",
+			}})
+		})
+
+		g.It("matches actions, addresses and values before a destroy", func() {
+			results := evaluate(`
+rules:
+  - name: prod-db-destroy
+    severity: warn
+    address: module.*.aws_db_instance.*
+    actions: [delete, replace]
+    when:
+      - tags.env == prod
+      - ingress.port == 5432
+`)
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].Severity).Equal(SeverityWarn)
+			g.Assert(results[0].Message).Equal("module.db.aws_db_instance.main matches rule prod-db-destroy")
+		})
+
+		g.It("supports negated and regexp operators", func() {
+			results := evaluate(`
+rules:
+  - name: ports
+    type: aws_security_group_rule
+    when:
+      - cidr_blocks not_contains 0.0.0.0/0
+      - from_port matches ^44
+`)
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].Address).Equal("aws_security_group_rule.internal")
+		})
+
+		g.It("ignores resources without changes", func() {
+			results := evaluate(`
+rules:
+  - name: public-buckets
+    type: aws_s3_bucket
+    when:
+      - acl == public-read
+`)
+			g.Assert(len(results)).Equal(0)
+		})
+
+		g.It("rejects invalid rules", func() {
+			_, err := ParseRules([]byte("rules:\n  - name: a\n    severity: fatal\n"))
+			g.Assert(err != nil).IsTrue("should have received error for an invalid severity")
+			_, err = ParseRules([]byte("rules:\n  - name: a\n    when: [\"cidr_blocks includes 0.0.0.0/0\"]\n"))
+			g.Assert(err != nil).IsTrue("should have received error for an invalid operator")
+			_, err = ParseRules([]byte("rules:\n  - severity: warn\n"))
+			g.Assert(err != nil).IsTrue("should have received error for a missing name")
+		})
+	})
+}
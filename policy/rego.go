@@ -0,0 +1,172 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+// rRegoRule matches the rule names collected as results, the same as Conftest
+var rRegoRule = regexp.MustCompile(`^(deny|violation|warn)(_[a-zA-Z0-9]+)*$`)
+
+type (
+	// RegoPolicies are compiled Rego modules evaluated against the JSON plan document
+	RegoPolicies struct {
+		compiler *ast.Compiler
+		queries  []regoQuery
+	}
+
+	// regoQuery is a deny, violation or warn rule of a package
+	regoQuery struct {
+		Name     string
+		Query    string
+		Severity string
+	}
+)
+
+// LoadRego parses and compiles the .rego files of a directory and its subdirectories,
+// skipping test files
+func LoadRego(dir string) (*RegoPolicies, error) {
+	modules := map[string]*ast.Module{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(file) != ".rego" || strings.HasSuffix(file, "_test.rego") {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		module, err := ast.ParseModule(file, string(b))
+		if err != nil {
+			return err
+		}
+		modules[file] = module
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load Rego policies. %s", err)
+	}
+
+	return compileRego(modules)
+}
+
+func compileRego(modules map[string]*ast.Module) (*RegoPolicies, error) {
+	compiler := ast.NewCompiler()
+	if compiler.Compile(modules); compiler.Failed() {
+		return nil, fmt.Errorf("Failed to compile Rego policies. %s", compiler.Errors)
+	}
+
+	policies := &RegoPolicies{compiler: compiler}
+	seen := map[string]bool{}
+	for _, file := range sortedKeys(modules) {
+		module := modules[file]
+		pkg := strings.TrimPrefix(module.Package.Path.String(), "data.")
+		for _, rule := range module.Rules {
+			name := string(rule.Head.Name)
+			query := fmt.Sprintf("data.%s.%s", pkg, name)
+			if !rRegoRule.MatchString(name) || seen[query] {
+				continue
+			}
+			seen[query] = true
+
+			severity := SeverityDeny
+			if strings.HasPrefix(name, "warn") {
+				severity = SeverityWarn
+			}
+			policies.queries = append(policies.queries, regoQuery{
+				Name:     pkg + "." + name,
+				Query:    query,
+				Severity: severity,
+			})
+		}
+	}
+
+	return policies, nil
+}
+
+// Evaluate returns a result for every message of the deny, violation and warn rules.
+// Messages are strings, or objects with a msg key.
+func (rp *RegoPolicies) Evaluate(ctx context.Context, plan *parser.Plan) ([]Result, error) {
+	var input interface{}
+	if err := json.Unmarshal(plan.Raw, &input); err != nil {
+		return nil, fmt.Errorf("Failed to parse plan JSON. %s", err)
+	}
+
+	var results []Result
+	for _, q := range rp.queries {
+		rs, err := rego.New(
+			rego.Query(q.Query),
+			rego.Compiler(rp.compiler),
+			rego.Input(input),
+		).Eval(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to evaluate %s. %s", q.Name, err)
+		}
+
+		for _, r := range rs {
+			for _, expr := range r.Expressions {
+				for _, msg := range regoMessages(expr.Value) {
+					if msg == "" {
+						msg = fmt.Sprintf("plan matches %s", q.Name)
+					}
+					results = append(results, Result{
+						Rule:     q.Name,
+						Severity: q.Severity,
+						Message:  msg,
+					})
+				}
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// regoMessages flattens the value of a rule into messages
+func regoMessages(value interface{}) []string {
+	switch t := value.(type) {
+	case []interface{}:
+		var messages []string
+		for _, v := range t {
+			messages = append(messages, regoMessages(v)...)
+		}
+		return messages
+	case map[string]interface{}:
+		if msg, ok := t["msg"]; ok {
+			return []string{scalar(msg)}
+		}
+	case bool:
+		// a rule like `deny { ... }` is true without a message
+		if !t {
+			return nil
+		}
+		return []string{""}
+	}
+
+	return []string{scalar(value)}
+}
+
+func sortedKeys(m map[string]*ast.Module) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+func TestRego(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Rego", func() {
+		plan, err := parser.ParsePlan([]byte(planJSON))
+		if err != nil {
+			g.Fail("Cannot parse the plan")
+		}
+
+		var dir string
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "rego")
+		})
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		write := func(name, content string) {
+			os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+			ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		}
+
+		g.It("collects deny, violation and warn messages of all packages", func() {
+			write("main.rego", `package main
+
+deny[msg] {
+	rc := input.resource_changes[_]
+	rc.change.actions[_] == "delete"
+	msg := sprintf("%s must not be destroyed", [rc.address])
+}
+
+warn_ingress[msg] {
+	rc := input.resource_changes[_]
+	rc.change.after.cidr_blocks[_] == "0.0.0.0/0"
+	msg := sprintf("%s is open to the internet", [rc.address])
+}
+`)
+			write("nested/tags.rego", `package terraform.tags
+
+violation[{"msg": msg}] {
+	rc := input.resource_changes[_]
+	rc.type == "aws_s3_bucket"
+	msg := sprintf("%s has no tags", [rc.address])
+}
+`)
+			write("main_test.rego", `package main
+
+test_nothing { true }
+`)
+
+			policies, err := LoadRego(dir)
+			g.Assert(err == nil).IsTrue("should load the policies")
+
+			results, err := policies.Evaluate(context.Background(), plan)
+			g.Assert(err == nil).IsTrue("should evaluate the policies")
+			g.Assert(results).Equal([]Result{
+				{Rule: "main.deny", Severity: SeverityDeny, Message: "module.db.aws_db_instance.main must not be destroyed"},
+				{Rule: "main.warn_ingress", Severity: SeverityWarn, Message: "aws_security_group_rule.open is open to the internet"},
+				{Rule: "terraform.tags.violation", Severity: SeverityDeny, Message: "aws_s3_bucket.logs has no tags"},
+			})
+		})
+
+		g.It("reports boolean rules without a message", func() {
+			write("main.rego", `package main
+
+deny {
+	count(input.resource_changes) > 3
+}
+`)
+
+			policies, err := LoadRego(dir)
+			g.Assert(err == nil).IsTrue("should load the policies")
+
+			results, _ := policies.Evaluate(context.Background(), plan)
+			g.Assert(results).Equal([]Result{
+				{Rule: "main.deny", Severity: SeverityDeny, Message: "plan matches main.deny"},
+			})
+		})
+
+		g.It("fails on invalid policies", func() {
+			write("main.rego", `package main
+
+deny[msg] {
+	msg := undefined_function(input)
+}
+`)
+
+			_, err := LoadRego(dir)
+			g.Assert(err != nil).IsTrue("should fail to compile")
+		})
+	})
+}
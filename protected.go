@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+var rModulePrefixes = regexp.MustCompile(`^(module\.[^.]+\.)+`)
+
+// checkProtected returns a violation for every destroyed or replaced resource matching
+// a protected pattern. Patterns with a dot match the resource address, with or without
+// instance keys, and patterns without one match the resource type.
+func (p Plugin) checkProtected(plan *parser.Plan) []string {
+	var violations []string
+
+	for _, rc := range plan.ResourceChanges {
+		action := rc.Action()
+		if action != parser.ActionDelete && action != parser.ActionReplace {
+			continue
+		}
+
+		pattern := matchProtected(p.Config.ProtectedResources, rc.Address)
+		if pattern == "" {
+			continue
+		}
+
+		verb := "destroyed"
+		if action == parser.ActionReplace {
+			verb = "replaced"
+		}
+		violations = append(violations, fmt.Sprintf("protected resource %s will be %s (matches %s)", rc.Address, verb, pattern))
+	}
+
+	return violations
+}
+
+// matchProtected returns the first pattern matching the address, or an empty string
+func matchProtected(patterns []string, address string) string {
+	unkeyed := parser.StripInstanceKeys(address)
+	resourceType := addressType(unkeyed)
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		candidates := []string{resourceType}
+		if strings.Contains(pattern, ".") {
+			candidates = []string{address, unkeyed}
+		}
+
+		for _, candidate := range candidates {
+			matched, err := path.Match(pattern, candidate)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"pattern": pattern,
+					"error":   err,
+				}).Warn("Ignoring invalid protected resource pattern")
+				break
+			}
+			if matched {
+				return pattern
+			}
+		}
+	}
+
+	return ""
+}
+
+// addressType returns the resource type of an address without instance keys
+func addressType(address string) string {
+	address = rModulePrefixes.ReplaceAllString(address, "")
+	address = strings.TrimPrefix(address, "data.")
+
+	return strings.SplitN(address, ".", 2)[0]
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+func TestProtected(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("matchProtected", func() {
+		patterns := []string{"module.*.aws_db_instance.*", "aws_s3_bucket.state", "aws_kms_*"}
+
+		g.It("matches addresses", func() {
+			g.Assert(matchProtected(patterns, "module.db.aws_db_instance.main")).Equal("module.*.aws_db_instance.*")
+			g.Assert(matchProtected(patterns, `module.db["prod"].aws_db_instance.main[0]`)).Equal("module.*.aws_db_instance.*")
+			g.Assert(matchProtected(patterns, "aws_s3_bucket.state[0]")).Equal("aws_s3_bucket.state")
+		})
+
+		g.It("matches resource types", func() {
+			g.Assert(matchProtected(patterns, "module.a.module.b.aws_kms_key.main")).Equal("aws_kms_*")
+			g.Assert(matchProtected(patterns, "aws_kms_alias.main")).Equal("aws_kms_*")
+		})
+
+		g.It("does not match other resources", func() {
+			g.Assert(matchProtected(patterns, "aws_db_instance.main")).Equal("")
+			g.Assert(matchProtected(patterns, "aws_s3_bucket.logs")).Equal("")
+		})
+	})
+
+	g.Describe("checkProtected", func() {
+		g.It("reports destroyed and replaced protected resources", func() {
+			p := Plugin{Config: Config{ProtectedResources: []string{"aws_db_instance"}}}
+			violations := p.checkProtected(&parser.Plan{ResourceChanges: []parser.ResourceChange{
+				{Address: "aws_db_instance.a", Change: parser.Change{Actions: []string{"delete"}}},
+				{Address: "aws_db_instance.b", Change: parser.Change{Actions: []string{"create", "delete"}}},
+				{Address: "aws_db_instance.c", Change: parser.Change{Actions: []string{"update"}}},
+			}})
+			g.Assert(violations).Equal([]string{
+				"protected resource aws_db_instance.a will be destroyed (matches aws_db_instance)",
+				"protected resource aws_db_instance.b will be replaced (matches aws_db_instance)",
+			})
+		})
+	})
+}
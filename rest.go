@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+type (
+	// restClient is a minimal JSON API client for the SCMs without a Go SDK in use
+	restClient struct {
+		baseURL *url.URL
+		client  *http.Client
+		header  http.Header
+		user    *url.Userinfo
+	}
+
+	// restError is returned for non 2xx API responses
+	restError struct {
+		Method     string
+		URL        string
+		StatusCode int
+		Body       string
+	}
+)
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+func newRESTClient(base string, client *http.Client) (*restClient, error) {
+	baseURL, err := parseBaseURL(base)
+	if err != nil {
+		return nil, err
+	}
+
+	return &restClient{
+		baseURL: baseURL,
+		client:  client,
+		header:  http.Header{},
+	}, nil
+}
+
+// do sends the request with in encoded as JSON body and decodes the response into out,
+// path is relative to the base URL
+func (c *restClient) do(ctx context.Context, method, path string, in, out interface{}) (*http.Response, error) {
+	u, err := c.baseURL.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if c.user != nil {
+		password, _ := c.user.Password()
+		req.SetBasicAuth(c.user.Username(), password)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, &restError{
+			Method:     method,
+			URL:        u.String(),
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(b)),
+		}
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+			return resp, fmt.Errorf("Failed to decode %s %s. %s", method, u.String(), err)
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	resultCreated = "created"
+	resultUpdated = "updated"
+)
+
+type (
+	// Result describes the comment written by the plugin, for later pipeline steps to consume
+	Result struct {
+		Action     string `json:"action"`
+		IssueNum   int    `json:"issue_number"`
+		CommentID  int64  `json:"comment_id"`
+		CommentURL string `json:"comment_url"`
+	}
+)
+
+// createComment posts a new comment to the PR
+func (p Plugin) createComment(body string) (*Comment, error) {
+	body, err := p.scanComment(body)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := p.Config.scm.CreateComment(p.Config.scmContext, p.Config.IssueNum, body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create comment in PR #%d. %s", p.Config.IssueNum, err)
+	}
+
+	return comment, p.report(resultCreated, comment)
+}
+
+// editComment replaces the body of an existing comment in the PR
+func (p Plugin) editComment(id int64, body string) (*Comment, error) {
+	body, err := p.scanComment(body)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := p.Config.scm.EditComment(p.Config.scmContext, p.Config.IssueNum, id, body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to update comment %d in PR #%d. %s", id, p.Config.IssueNum, err)
+	}
+
+	return comment, p.report(resultUpdated, comment)
+}
+
+// report logs the written comment and saves it to the output file, when configured
+func (p Plugin) report(action string, comment *Comment) error {
+	result := Result{
+		Action:     action,
+		IssueNum:   p.Config.IssueNum,
+		CommentID:  comment.ID,
+		CommentURL: comment.URL,
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":  result.CommentID,
+		"url": result.CommentURL,
+	}).Infof("Comment %s in PR", action)
+
+	if p.Config.OutputFile == "" {
+		return nil
+	}
+
+	return writeResult(p.Config.OutputFile, result)
+}
+
+func writeResult(path string, result Result) error {
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Failed to write output file. %s", err)
+	}
+	if err := ioutil.WriteFile(path, append(out, '\n'), 0644); err != nil {
+		return fmt.Errorf("Failed to write output file. %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+const (
+	outputComment = "comment"
+	outputReview  = "review"
+)
+
+var (
+	outputs = []string{outputComment, outputReview}
+
+	rHunk = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+)
+
+type (
+	// resourceLocation is where a resource block is declared, relative to the repository root
+	resourceLocation struct {
+		Path string
+		Line int
+	}
+)
+
+// PostReview creates a PR review with inline comments on the resource blocks of
+// destroyed and replaced resources, for the blocks that are part of the PR diff. The
+// review is skipped when the previous one has the same comments, and the comments of
+// previous reviews are cleaned up like previous plan comments.
+func (g *GitHub) PostReview(ctx context.Context, number int, review Review) error {
+	positions, err := g.pullRequestPositions(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	var comments []*github.DraftReviewComment
+	for _, rc := range review.Plan.ResourceChanges {
+		action := rc.Action()
+		if action != parser.ActionDelete && action != parser.ActionReplace {
+			continue
+		}
+
+		loc, err := locateResource(review.Workspace, review.RootDir, review.Plan.Configuration, rc)
+		if err != nil {
+			return err
+		}
+		if loc == nil {
+			logrus.WithFields(logrus.Fields{
+				"address": rc.Address,
+			}).Debug("Resource declaration not found, skipping review comment")
+			continue
+		}
+
+		position, ok := positions[loc.Path][loc.Line]
+		if !ok {
+			continue
+		}
+
+		body := reviewCommentBody(rc, action)
+		comments = append(comments, &github.DraftReviewComment{
+			Path:     github.String(loc.Path),
+			Position: github.Int(position),
+			Body:     github.String(body),
+		})
+	}
+
+	if len(comments) == 0 {
+		logrus.Info("No destroyed or replaced resources in the PR diff, skipping review")
+		return nil
+	}
+
+	previous, err := g.previousReviews(ctx, number, review.Key)
+	if err != nil {
+		return err
+	}
+	digest := reviewDigest(comments)
+	if len(previous) > 0 && strings.Contains(previous[len(previous)-1].GetBody(), digest) {
+		logrus.Info("Review comments unchanged since the previous review, skipping review")
+		return nil
+	}
+
+	req := &github.PullRequestReviewRequest{
+		Body:     github.String(fmt.Sprintf("**%s**: %d resource(s) in this PR will be destroyed or replaced.\n%s\n%s\n", review.Title, len(comments), commentMarker(review.Key), digest)),
+		Event:    github.String("COMMENT"),
+		Comments: comments,
+	}
+	if review.CommitSha != "" {
+		req.CommitID = github.String(review.CommitSha)
+	}
+
+	created, _, err := g.client.PullRequests.CreateReview(ctx, g.owner, g.repo, number, req)
+	if err != nil {
+		return fmt.Errorf("Failed to create review in PR #%d. %s", number, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":       created.GetID(),
+		"url":      created.GetHTMLURL(),
+		"comments": len(comments),
+	}).Info("Created review in PR")
+
+	for _, r := range previous {
+		if err := g.cleanupReview(ctx, number, r.GetID(), review.PreviousComments); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("Failed to clean up previous review in PR")
+		}
+	}
+
+	return nil
+}
+
+// previousReviews returns the reviews posted by the plugin with the key, oldest first
+func (g *GitHub) previousReviews(ctx context.Context, number int, key string) ([]*github.PullRequestReview, error) {
+	var previous []*github.PullRequestReview
+
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := g.client.PullRequests.ListReviews(ctx, g.owner, g.repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range reviews {
+			if strings.Contains(r.GetBody(), commentMarker(key)) {
+				previous = append(previous, r)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return previous, nil
+}
+
+// cleanupReview deletes or minimizes the inline comments of a previous review, since
+// reviews with only comments cannot be dismissed
+func (g *GitHub) cleanupReview(ctx context.Context, number int, id int64, mode string) error {
+	if mode == "" || mode == previousCommentsKeep {
+		return nil
+	}
+
+	comments, _, err := g.client.PullRequests.ListReviewComments(ctx, g.owner, g.repo, number, id, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range comments {
+		switch mode {
+		case previousCommentsDelete:
+			_, err = g.client.PullRequests.DeleteComment(ctx, g.owner, g.repo, comment.GetID())
+		case previousCommentsMinimize:
+			err = g.minimize(ctx, fmt.Sprintf("repos/%s/%s/pulls/comments/%d", g.owner, g.repo, comment.GetID()), comment.GetID())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":   id,
+		"mode": mode,
+	}).Info("Cleaned up previous review in PR")
+
+	return nil
+}
+
+// reviewDigest is a hidden marker identifying the inline comments of a review
+func reviewDigest(comments []*github.DraftReviewComment) string {
+	h := sha256.New()
+	for _, c := range comments {
+		fmt.Fprintf(h, "%s:%d:%s\n", c.GetPath(), c.GetPosition(), c.GetBody())
+	}
+
+	return fmt.Sprintf("<!-- review: %x -->", h.Sum(nil))
+}
+
+func reviewCommentBody(rc parser.ResourceChange, action string) string {
+	verb := "destroyed"
+	if action == parser.ActionReplace {
+		verb = "replaced"
+	}
+
+	return fmt.Sprintf(":warning: `%s` will be **%s**.", rc.Address, verb)
+}
+
+// pullRequestPositions maps the file paths and new line numbers of the PR diff to
+// their position in the diff, which is how review comments are anchored
+func (g *GitHub) pullRequestPositions(ctx context.Context, number int) (map[string]map[int]int, error) {
+	positions := map[string]map[int]int{}
+
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := g.client.PullRequests.ListFiles(ctx, g.owner, g.repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			positions[f.GetFilename()] = diffPositions(f.GetPatch())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return positions, nil
+}
+
+// diffPositions maps the new line numbers of a unified diff patch to their position,
+// counted from the first hunk header
+func diffPositions(patch string) map[int]int {
+	positions := map[int]int{}
+	position := 0
+	line := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(patch))
+	for scanner.Scan() {
+		text := scanner.Text()
+		if m := rHunk.FindStringSubmatch(text); m != nil {
+			if position > 0 || line > 0 {
+				position++
+			}
+			line, _ = strconv.Atoi(m[1])
+			continue
+		}
+
+		position++
+		switch {
+		case strings.HasPrefix(text, "-"), strings.HasPrefix(text, "\\"):
+		default:
+			positions[line] = position
+			line++
+		}
+	}
+
+	return positions
+}
+
+// locateResource finds the resource block of a resource change in the local modules
+// of the configuration, returning nil when it is declared in a remote module or outside
+// the workspace. The path is relative to the workspace, which is the repository root.
+func locateResource(workspace, rootDir string, config parser.Configuration, rc parser.ResourceChange) (*resourceLocation, error) {
+	dir := rootDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspace, dir)
+	}
+	module := config.RootModule
+	for _, name := range rc.ModuleCallPath() {
+		call, ok := module.ModuleCalls[name]
+		if !ok || !isLocalSource(call.Source) {
+			return nil, nil
+		}
+		dir = filepath.Join(dir, call.Source)
+		module = call.Module
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	block := regexp.MustCompile(fmt.Sprintf(`^\s*resource\s+"%s"\s+"%s"\s*\{`, regexp.QuoteMeta(rc.Type), regexp.QuoteMeta(rc.Name)))
+	if rc.Mode == "data" {
+		block = regexp.MustCompile(fmt.Sprintf(`^\s*data\s+"%s"\s+"%s"\s*\{`, regexp.QuoteMeta(rc.Type), regexp.QuoteMeta(rc.Name)))
+	}
+
+	for _, file := range files {
+		rel, err := filepath.Rel(workspace, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, nil
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, l := range bytes.Split(b, []byte("\n")) {
+			if block.Match(l) {
+				return &resourceLocation{
+					Path: filepath.ToSlash(rel),
+					Line: i + 1,
+				}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func isLocalSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/google/go-github/github"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+// fakeReviews is an in-memory stand-in for the pull request files and reviews API
+type fakeReviews struct {
+	patches  map[string]string
+	reviews  []*github.PullRequestReview
+	comments map[int64][]*github.PullRequestComment
+	deleted  []int64
+}
+
+func (f *fakeReviews) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case r.URL.Path == "/repos/owner/repo/pulls/7/files":
+		var files []map[string]string
+		for name, patch := range f.patches {
+			files = append(files, map[string]string{"filename": name, "patch": patch})
+		}
+		write(files)
+	case r.URL.Path == "/repos/owner/repo/pulls/7/reviews" && r.Method == "GET":
+		write(f.reviews)
+	case r.URL.Path == "/repos/owner/repo/pulls/7/reviews" && r.Method == "POST":
+		var req github.PullRequestReviewRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		id := int64(len(f.reviews) + 1)
+		f.reviews = append(f.reviews, &github.PullRequestReview{ID: github.Int64(id), Body: req.Body})
+		for i, c := range req.Comments {
+			f.comments[id] = append(f.comments[id], &github.PullRequestComment{
+				ID:   github.Int64(id*100 + int64(i)),
+				Path: c.Path,
+				Body: c.Body,
+			})
+		}
+		write(f.reviews[len(f.reviews)-1])
+	case len(parts) == 8 && parts[5] == "reviews" && parts[7] == "comments":
+		id, _ := strconv.ParseInt(parts[6], 10, 64)
+		write(f.comments[id])
+	case len(parts) == 6 && parts[3] == "pulls" && parts[4] == "comments" && r.Method == "DELETE":
+		id, _ := strconv.ParseInt(parts[5], 10, 64)
+		f.deleted = append(f.deleted, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestReview(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("diffPositions", func() {
+		g.It("maps new lines to their position in the diff", func() {
+			patch := `@@ -1,3 +1,4 @@
+ resource "aws_s3_bucket" "state" {
+-  bucket = "old"
++  bucket = "new"
++  acl    = "private"
+ }
+@@ -10,2 +11,3 @@ resource "aws_kms_key" "state" {
+ resource "aws_db_instance" "main" {
++  engine = "postgres"
+ }`
+			positions := diffPositions(patch)
+			g.Assert(positions).Equal(map[int]int{
+				1:  1,
+				2:  3,
+				3:  4,
+				4:  5,
+				11: 7,
+				12: 8,
+				13: 9,
+			})
+		})
+
+		g.It("returns no positions for binary files", func() {
+			g.Assert(len(diffPositions(""))).Equal(0)
+		})
+	})
+
+	g.Describe("locateResource", func() {
+		var workspace string
+		rc := parser.ResourceChange{Address: "module.db.aws_db_instance.main", ModuleAddress: "module.db", Mode: "managed", Type: "aws_db_instance", Name: "main"}
+		config := parser.Configuration{
+			RootModule: parser.ConfigModule{
+				ModuleCalls: map[string]parser.ModuleCall{
+					"db":     {Source: "./modules/db"},
+					"remote": {Source: "terraform-aws-modules/vpc/aws"},
+				},
+			},
+		}
+
+		g.BeforeEach(func() {
+			workspace, _ = ioutil.TempDir("", "workspace")
+			os.MkdirAll(filepath.Join(workspace, "stacks", "prod", "modules", "db"), 0755)
+			ioutil.WriteFile(filepath.Join(workspace, "stacks", "prod", "modules", "db", "main.tf"), []byte("locals {}\n\nresource \"aws_db_instance\" \"main\" {\n}\n"), 0644)
+		})
+		g.AfterEach(func() {
+			os.RemoveAll(workspace)
+		})
+
+		g.It("returns the path relative to the repository root", func() {
+			for _, rootDir := range []string{"stacks/prod", filepath.Join(workspace, "stacks", "prod")} {
+				loc, err := locateResource(workspace, rootDir, config, rc)
+				g.Assert(err == nil).IsTrue("should not error")
+				g.Assert(*loc).Equal(resourceLocation{Path: "stacks/prod/modules/db/main.tf", Line: 3})
+			}
+		})
+
+		g.It("skips resources of remote modules and outside the workspace", func() {
+			remote := rc
+			remote.Address = "module.remote.aws_db_instance.main"
+			remote.ModuleAddress = "module.remote"
+			loc, err := locateResource(workspace, "stacks/prod", config, remote)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(loc == nil).IsTrue("should not locate remote resources")
+
+			loc, err = locateResource(filepath.Join(workspace, "stacks", "other"), filepath.Join(workspace, "stacks", "prod"), config, rc)
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(loc == nil).IsTrue("should not locate resources outside the workspace")
+		})
+	})
+
+	g.Describe("PostReview", func() {
+		var fake *fakeReviews
+		var server *httptest.Server
+		var gh *GitHub
+		var review Review
+		var workspace string
+
+		g.BeforeEach(func() {
+			workspace, _ = ioutil.TempDir("", "workspace")
+			ioutil.WriteFile(filepath.Join(workspace, "main.tf"), []byte("resource \"aws_s3_bucket\" \"logs\" {\n}\n"), 0644)
+
+			fake = &fakeReviews{
+				patches:  map[string]string{"main.tf": "@@ -0,0 +1,2 @@\n+resource \"aws_s3_bucket\" \"logs\" {\n+}"},
+				comments: map[int64][]*github.PullRequestComment{},
+			}
+			server = httptest.NewServer(fake)
+			scm, _ := newSCM(Config{BaseURL: server.URL + "/", Token: "secret", SCM: scmGitHub, RepoOwner: "owner", RepoName: "repo"})
+			gh = scm.(*GitHub)
+
+			review = Review{
+				Key:       "key",
+				Title:     "Terraform Plan Output",
+				Workspace: workspace,
+				Plan: &parser.Plan{
+					ResourceChanges: []parser.ResourceChange{
+						{Address: "aws_s3_bucket.logs", Mode: "managed", Type: "aws_s3_bucket", Name: "logs", Change: parser.Change{Actions: []string{"delete"}}},
+					},
+				},
+			}
+		})
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(workspace)
+		})
+
+		g.It("marks the review and skips it when the comments did not change", func() {
+			g.Assert(gh.PostReview(context.Background(), 7, review) == nil).IsTrue("should not error")
+			g.Assert(gh.PostReview(context.Background(), 7, review) == nil).IsTrue("should not error")
+
+			g.Assert(len(fake.reviews)).Equal(1)
+			g.Assert(strings.Contains(fake.reviews[0].GetBody(), commentMarker("key"))).IsTrue("should mark the review")
+			g.Assert(fake.comments[1][0].GetBody()).Equal(":warning: `aws_s3_bucket.logs` will be **destroyed**.")
+		})
+
+		g.It("cleans up the comments of the previous review when the comments changed", func() {
+			g.Assert(gh.PostReview(context.Background(), 7, review) == nil).IsTrue("should not error")
+
+			review.Plan.ResourceChanges[0].Change.Actions = []string{"delete", "create"}
+			review.PreviousComments = previousCommentsDelete
+			g.Assert(gh.PostReview(context.Background(), 7, review) == nil).IsTrue("should not error")
+
+			g.Assert(len(fake.reviews)).Equal(2)
+			g.Assert(fake.comments[2][0].GetBody()).Equal(":warning: `aws_s3_bucket.logs` will be **replaced**.")
+			g.Assert(fake.deleted).Equal([]int64{100})
+		})
+
+		g.It("does not mix up the reviews of other stacks", func() {
+			fake.reviews = append(fake.reviews, &github.PullRequestReview{
+				ID:   github.Int64(1),
+				Body: github.String(fmt.Sprintf("other\n%s\n%s", commentMarker("other"), "<!-- review: x -->")),
+			})
+			g.Assert(gh.PostReview(context.Background(), 7, review) == nil).IsTrue("should not error")
+			g.Assert(len(fake.reviews)).Equal(2)
+			g.Assert(len(fake.deleted)).Equal(0)
+		})
+	})
+}
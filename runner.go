@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os/exec"
+)
+
+type (
+	// Runner runs the commands of the plugin, which are fully set up by RunCommand
+	Runner interface {
+		Run(c *exec.Cmd) error
+	}
+
+	// execRunner runs the commands as child processes
+	execRunner struct{}
+)
+
+// Run starts the command and waits for it to complete
+func (execRunner) Run(c *exec.Cmd) error {
+	return c.Run()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+const (
+	scmGitHub    = "github"
+	scmGitLab    = "gitlab"
+	scmGitea     = "gitea"
+	scmBitbucket = "bitbucket"
+)
+
+var scms = []string{scmGitHub, scmGitLab, scmGitea, scmBitbucket}
+
+type (
+	// SCM posts the plan to the pull requests of a source code management system
+	SCM interface {
+		// PullRequestNumber returns the number of the pull request for a commit, 0 when there is none
+		PullRequestNumber(ctx context.Context, sha string) (int, error)
+		// FindComment returns the plugin comment with the key, nil when there is none
+		FindComment(ctx context.Context, number int, key string) (*Comment, error)
+		// ListComments returns all comments of the pull request
+		ListComments(ctx context.Context, number int) ([]*Comment, error)
+		CreateComment(ctx context.Context, number int, body string) (*Comment, error)
+		EditComment(ctx context.Context, number int, id int64, body string) (*Comment, error)
+		DeleteComment(ctx context.Context, number int, id int64) error
+	}
+
+	// Comment is a pull request comment
+	Comment struct {
+		ID        int64
+		URL       string
+		Body      string
+		UpdatedAt time.Time
+	}
+
+	// commentMinimizer is implemented by SCMs able to hide comments without deleting them
+	commentMinimizer interface {
+		MinimizeComment(ctx context.Context, number int, id int64) error
+	}
+
+	// reviewPoster is implemented by SCMs supporting reviews with inline comments
+	reviewPoster interface {
+		PostReview(ctx context.Context, number int, review Review) error
+	}
+
+	// labeler is implemented by SCMs supporting pull request labels
+	labeler interface {
+		SetLabels(ctx context.Context, number int, labels []string, stale []string, colors map[string]string) error
+	}
+
+	// Review is the input for posting the plan as a pull request review
+	Review struct {
+		Key              string
+		CommitSha        string
+		Title            string
+		Workspace        string
+		RootDir          string
+		PreviousComments string
+		Plan             *parser.Plan
+	}
+)
+
+// scmTokenEnvs are the environment variables, usually secrets, holding the token of
+// each SCM. Only those of the SCM in use are read, so a token is never sent to another SCM.
+var scmTokenEnvs = map[string][]string{
+	scmGitHub:    {"GITHUB_RELEASE_API_KEY", "GITHUB_TOKEN"},
+	scmGitLab:    {"GITLAB_TOKEN"},
+	scmGitea:     {"GITEA_TOKEN"},
+	scmBitbucket: {"BITBUCKET_TOKEN"},
+}
+
+// scmName resolves the configured SCM, where Forgejo is served by the Gitea API and
+// Stash is the former name of Bitbucket Server
+func scmName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return scmGitHub, nil
+	case "forgejo":
+		return scmGitea, nil
+	case "stash", "bitbucket-server":
+		return scmBitbucket, nil
+	}
+	if !contains(scms, name) {
+		return "", fmt.Errorf("SCM is invalid, required one of [%s]", strings.Join(scms, ","))
+	}
+
+	return name, nil
+}
+
+// detectSCM guesses the SCM from the host of the repository URL, returning an empty
+// string for hosts it cannot tell, like most self-hosted instances
+func detectSCM(remoteURL string) string {
+	host := remoteHost(remoteURL)
+	switch {
+	case strings.Contains(host, "github"):
+		return scmGitHub
+	case strings.Contains(host, "gitlab"):
+		return scmGitLab
+	case strings.Contains(host, "gitea"), strings.Contains(host, "forgejo"), host == "codeberg.org":
+		return scmGitea
+	// Bitbucket Cloud has a different API, which is not supported
+	case host == "bitbucket.org":
+		return ""
+	case strings.Contains(host, "bitbucket"), strings.Contains(host, "stash"):
+		return scmBitbucket
+	}
+
+	return ""
+}
+
+// detectBaseURL returns the API base URL of the SCM on the host of the repository URL, so
+// a self-hosted server does not get the credentials meant for it sent to github.com or
+// gitlab.com. It is empty for github.com and gitlab.com, which have the default ones.
+func detectBaseURL(name, remoteURL string) string {
+	u, err := url.Parse(strings.TrimSpace(remoteURL))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	host := strings.ToLower(u.Hostname())
+	base := u.Scheme + "://" + u.Host + "/"
+	switch name {
+	case scmGitHub:
+		if host == "github.com" {
+			return ""
+		}
+		return base + "api/v3/"
+	case scmGitLab:
+		if host == "gitlab.com" {
+			return ""
+		}
+		return base + "api/v4/"
+	case scmGitea:
+		return base + "api/v1/"
+	case scmBitbucket:
+		return base
+	}
+
+	return ""
+}
+
+// remoteHost returns the host of the repository URL, empty when it is not known
+func remoteHost(remoteURL string) string {
+	u, err := url.Parse(strings.TrimSpace(remoteURL))
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// scmToken returns the token of the SCM from its environment variables
+func scmToken(name string, getenv func(string) string) string {
+	for _, env := range scmTokenEnvs[name] {
+		if token := getenv(env); token != "" {
+			return token
+		}
+	}
+
+	return ""
+}
+
+func newSCM(config Config) (SCM, error) {
+	name, err := scmName(config.SCM)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := caTransport(config.caBundle)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: NewRetryTransport(base, config.MaxRetries, config.Timeout),
+	}
+
+	switch name {
+	case scmGitLab:
+		return NewGitLab(config, client)
+	case scmGitea:
+		return NewGitea(config, client)
+	case scmBitbucket:
+		return NewBitbucket(config, client)
+	default:
+		return NewGitHub(config, client)
+	}
+}
+
+func commentMarker(key string) string {
+	return fmt.Sprintf("<!-- id: %s -->", key)
+}
+
+func filterComments(comments []*Comment, key string) []*Comment {
+	var matched []*Comment
+	for _, comment := range comments {
+		if strings.Contains(comment.Body, commentMarker(key)) {
+			matched = append(matched, comment)
+		}
+	}
+
+	return matched
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestSCM(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("scmName", func() {
+		g.It("resolves the SCM names and aliases", func() {
+			for name, expected := range map[string]string{
+				"":                 scmGitHub,
+				"github":           scmGitHub,
+				" GitLab ":         scmGitLab,
+				"gitea":            scmGitea,
+				"forgejo":          scmGitea,
+				"bitbucket":        scmBitbucket,
+				"bitbucket-server": scmBitbucket,
+				"stash":            scmBitbucket,
+			} {
+				scm, err := scmName(name)
+				g.Assert(err == nil).IsTrue("should not error for " + name)
+				g.Assert(scm).Equal(expected)
+			}
+		})
+
+		g.It("rejects unknown SCMs", func() {
+			for _, name := range []string{"git", "svn", "bitbucket-cloud"} {
+				_, err := scmName(name)
+				g.Assert(err != nil).IsTrue("should have received error for " + name)
+			}
+		})
+	})
+
+	g.Describe("detectSCM", func() {
+		g.It("detects the SCM from the repository URL", func() {
+			for remote, expected := range map[string]string{
+				"https://github.com/owner/repo.git":        scmGitHub,
+				"https://github.example.com/owner/repo":    scmGitHub,
+				"https://gitlab.com/group/sub/repo.git":    scmGitLab,
+				"https://codeberg.org/owner/repo.git":      scmGitea,
+				"https://gitea.example.com/owner/repo.git": scmGitea,
+				"https://bitbucket.example.com/scm/p/repo": scmBitbucket,
+				"https://bitbucket.org/owner/repo.git":     "",
+				"https://git.example.com/owner/repo.git":   "",
+				"":                                         "",
+			} {
+				g.Assert(detectSCM(remote)).Equal(expected)
+			}
+		})
+	})
+
+	g.Describe("detectBaseURL", func() {
+		g.It("derives the API base URL from the repository URL", func() {
+			for _, c := range []struct{ scm, remote, expected string }{
+				{scmGitHub, "https://github.com/owner/repo.git", ""},
+				{scmGitHub, "https://github.corp.example/owner/repo.git", "https://github.corp.example/api/v3/"},
+				{scmGitLab, "https://gitlab.com/group/repo.git", ""},
+				{scmGitLab, "https://gitlab.corp.example:8443/group/repo.git", "https://gitlab.corp.example:8443/api/v4/"},
+				{scmGitea, "http://gitea.local/owner/repo.git", "http://gitea.local/api/v1/"},
+				{scmBitbucket, "https://bitbucket.corp.example/scm/prj/repo.git", "https://bitbucket.corp.example/"},
+				{scmGitLab, "", ""},
+			} {
+				g.Assert(detectBaseURL(c.scm, c.remote)).Equal(c.expected)
+			}
+		})
+	})
+
+	g.Describe("setupSCM", func() {
+		g.It("uses the API of the detected self-hosted SCM", func() {
+			p := Plugin{Config: Config{
+				BaseURL:   defaultGitHubURL,
+				RemoteURL: "https://gitlab.corp.example/owner/repo.git",
+				Token:     "secret",
+				RepoOwner: "owner",
+				RepoName:  "repo",
+				Mode:      "simple",
+			}}
+			g.Assert(p.setupSCM() == nil).IsTrue("should not error")
+			g.Assert(p.Config.SCM).Equal(scmGitLab)
+			g.Assert(p.Config.BaseURL).Equal("https://gitlab.corp.example/api/v4/")
+		})
+
+		g.It("requires a base URL for a configured SCM on another host", func() {
+			p := Plugin{Config: Config{
+				SCM:       scmGitLab,
+				BaseURL:   defaultGitHubURL,
+				RemoteURL: "https://gitlab.corp.example/owner/repo.git",
+				Token:     "secret",
+				RepoOwner: "owner",
+				RepoName:  "repo",
+				Mode:      "simple",
+			}}
+			g.Assert(p.setupSCM() != nil).IsTrue("should have received error without a base URL")
+		})
+	})
+
+	g.Describe("scmToken", func() {
+		env := map[string]string{"GITHUB_TOKEN": "github", "GITLAB_TOKEN": "gitlab"}
+		getenv := func(key string) string { return env[key] }
+
+		g.It("only reads the token secrets of the SCM", func() {
+			g.Assert(scmToken(scmGitHub, getenv)).Equal("github")
+			g.Assert(scmToken(scmGitLab, getenv)).Equal("gitlab")
+			g.Assert(scmToken(scmGitea, getenv)).Equal("")
+			g.Assert(scmToken(scmBitbucket, getenv)).Equal("")
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+const (
+	// minSecretEntropy is the Shannon entropy, in bits per character, above which long
+	// tokens are considered secrets. Hex strings, like hashes and IDs, stay below 4.
+	minSecretEntropy = 4.5
+
+	secretWarning = "> :warning: **Possible secrets were redacted from this comment.** See the build log for the affected resources.\n\n"
+)
+
+var (
+	// secretFormats are the known credential formats
+	secretFormats = []struct {
+		Kind    string
+		Pattern *regexp.Regexp
+	}{
+		{"AWS access key", regexp.MustCompile(parser.AWSAccessKeyPattern)},
+		{"private key", regexp.MustCompile(parser.PrivateKeyPattern)},
+		{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+		{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+		{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+		{"JWT", regexp.MustCompile(parser.JWTPattern)},
+	}
+
+	rSecretToken    = regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`)
+	rResourceHeader = regexp.MustCompile(`(?m)^[\s~+\-#]*#\s+(\S+) (?:is tainted, so )?(?:will|must) be`)
+)
+
+type (
+	// secretFinding is a possible secret found in a comment body
+	secretFinding struct {
+		Kind    string
+		Address string
+		start   int
+		end     int
+	}
+)
+
+// scanComment redacts possible secrets from the comment body right before it is sent,
+// as a last line of defense after masking
+func (p Plugin) scanComment(body string) (string, error) {
+	allowlist, err := compileAllowlist(p.Config.SecretAllowlist)
+	if err != nil {
+		return "", err
+	}
+
+	redacted, findings := scanSecrets(body, allowlist)
+	if len(findings) == 0 {
+		return body, nil
+	}
+
+	for _, f := range findings {
+		logrus.WithFields(logrus.Fields{
+			"kind":    f.Kind,
+			"address": f.Address,
+		}).Warn("Redacted a possible secret from the comment")
+	}
+
+	return secretWarning + redacted, nil
+}
+
+func compileAllowlist(patterns []string) ([]*regexp.Regexp, error) {
+	var allowlist []*regexp.Regexp
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to compile secret allowlist pattern %q. %s", pattern, err)
+		}
+		allowlist = append(allowlist, re)
+	}
+
+	return allowlist, nil
+}
+
+// scanSecrets returns the body with the known credential formats and high-entropy
+// tokens replaced, and the findings. Values or resource addresses matching the
+// allowlist are kept.
+func scanSecrets(body string, allowlist []*regexp.Regexp) (string, []secretFinding) {
+	headers := rResourceHeader.FindAllStringSubmatchIndex(body, -1)
+	addressAt := func(offset int) string {
+		address := ""
+		for _, h := range headers {
+			if h[0] > offset {
+				break
+			}
+			address = body[h[2]:h[3]]
+		}
+		return address
+	}
+
+	var findings []secretFinding
+	add := func(kind string, start, end int) {
+		for _, f := range findings {
+			if start < f.end && end > f.start {
+				return
+			}
+		}
+
+		value := body[start:end]
+		address := addressAt(start)
+		for _, re := range allowlist {
+			if re.MatchString(value) || (address != "" && re.MatchString(address)) {
+				return
+			}
+		}
+		findings = append(findings, secretFinding{Kind: kind, Address: address, start: start, end: end})
+	}
+
+	for _, format := range secretFormats {
+		for _, loc := range format.Pattern.FindAllStringIndex(body, -1) {
+			add(format.Kind, loc[0], loc[1])
+		}
+	}
+	for _, loc := range rSecretToken.FindAllStringIndex(body, -1) {
+		if entropy(body[loc[0]:loc[1]]) >= minSecretEntropy {
+			add("high-entropy string", loc[0], loc[1])
+		}
+	}
+
+	if len(findings) == 0 {
+		return body, nil
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].start < findings[j].start
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(body[last:f.start])
+		b.WriteString(parser.RedactedValue)
+		last = f.end
+	}
+	b.WriteString(body[last:])
+
+	return b.String(), findings
+}
+
+// entropy returns the Shannon entropy of the string in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var e float64
+	for _, c := range counts {
+		f := float64(c) / float64(len(s))
+		e -= f * math.Log2(f)
+	}
+
+	return e
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/robertstettner/drone-terraform-github-commenter/parser"
+)
+
+const (
+	defaultTFCAddress = "https://app.terraform.io"
+	defaultTFCTimeout = 30 * time.Minute
+	tfcRunsPageSize   = 100
+)
+
+// tfcPollInterval is how often the plan status is checked while the run is planning
+var tfcPollInterval = 5 * time.Second
+
+type (
+	// TerraformCloud fetches the plans of remote runs from Terraform Cloud and Enterprise
+	TerraformCloud struct {
+		client *restClient
+		poll   time.Duration
+	}
+
+	tfcRelationship struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	tfcRun struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			PlanOnly bool `json:"plan-only"`
+		} `json:"attributes"`
+		Relationships struct {
+			Plan                 tfcRelationship `json:"plan"`
+			ConfigurationVersion tfcRelationship `json:"configuration-version"`
+		} `json:"relationships"`
+	}
+
+	tfcPlan struct {
+		Attributes struct {
+			Status string `json:"status"`
+		} `json:"attributes"`
+	}
+
+	tfcWorkspace struct {
+		ID string `json:"id"`
+	}
+
+	// tfcIncluded is a configuration version or its ingress attributes, included in the
+	// list of runs
+	tfcIncluded struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			CommitSha string `json:"commit-sha"`
+		} `json:"attributes"`
+		Relationships struct {
+			IngressAttributes tfcRelationship `json:"ingress-attributes"`
+		} `json:"relationships"`
+	}
+
+	tfcRunsPage struct {
+		Data     []tfcRun      `json:"data"`
+		Included []tfcIncluded `json:"included"`
+		Meta     struct {
+			Pagination struct {
+				NextPage int `json:"next-page"`
+			} `json:"pagination"`
+		} `json:"meta"`
+	}
+)
+
+// NewTerraformCloud creates the Terraform Cloud client, authenticated with the API token
+func NewTerraformCloud(address, token string, client *http.Client) (*TerraformCloud, error) {
+	if token == "" {
+		return nil, fmt.Errorf("You must provide a Terraform Cloud API token")
+	}
+	if address == "" {
+		address = defaultTFCAddress
+	}
+
+	c, err := newRESTClient(strings.TrimSuffix(address, "/")+"/api/v2/", client)
+	if err != nil {
+		return nil, err
+	}
+	c.header.Set("Authorization", "Bearer "+token)
+
+	return &TerraformCloud{
+		client: c,
+		poll:   tfcPollInterval,
+	}, nil
+}
+
+// FindRun returns the latest speculative run of the workspace for the commit, or the
+// latest speculative run when the commit is empty
+func (t *TerraformCloud) FindRun(ctx context.Context, organization, workspace, sha string) (string, error) {
+	var ws struct {
+		Data tfcWorkspace `json:"data"`
+	}
+	path := fmt.Sprintf("organizations/%s/workspaces/%s", url.PathEscape(organization), url.PathEscape(workspace))
+	if _, err := t.client.do(ctx, http.MethodGet, path, nil, &ws); err != nil {
+		return "", fmt.Errorf("Failed to get Terraform Cloud workspace. %s", err)
+	}
+
+	// runs are listed newest first, with the commits of their configuration versions
+	for page := 1; page != 0; {
+		var runs tfcRunsPage
+		path = fmt.Sprintf("workspaces/%s/runs?include=configuration_version.ingress_attributes&page%%5Bnumber%%5D=%d&page%%5Bsize%%5D=%d", url.PathEscape(ws.Data.ID), page, tfcRunsPageSize)
+		if _, err := t.client.do(ctx, http.MethodGet, path, nil, &runs); err != nil {
+			return "", fmt.Errorf("Failed to list Terraform Cloud runs. %s", err)
+		}
+
+		commits := runs.commits()
+		for _, run := range runs.Data {
+			if !run.Attributes.PlanOnly {
+				continue
+			}
+			if sha == "" || commits[run.Relationships.ConfigurationVersion.Data.ID] == sha {
+				return run.ID, nil
+			}
+		}
+		page = runs.Meta.Pagination.NextPage
+	}
+
+	if sha == "" {
+		return "", fmt.Errorf("No speculative run found in Terraform Cloud workspace %s/%s", organization, workspace)
+	}
+	return "", fmt.Errorf("No speculative run found in Terraform Cloud workspace %s/%s for commit %s", organization, workspace, sha)
+}
+
+// commits maps the included configuration versions to their commits. Configurations
+// uploaded by the CLI have no ingress attributes.
+func (r tfcRunsPage) commits() map[string]string {
+	shas := map[string]string{}
+	for _, included := range r.Included {
+		if included.Type == "ingress-attributes" {
+			shas[included.ID] = included.Attributes.CommitSha
+		}
+	}
+
+	commits := map[string]string{}
+	for _, included := range r.Included {
+		if included.Type == "configuration-versions" {
+			commits[included.ID] = shas[included.Relationships.IngressAttributes.Data.ID]
+		}
+	}
+
+	return commits
+}
+
+// PlanJSON waits for the plan of the run to finish and returns it in the format of
+// `terraform show -json`
+func (t *TerraformCloud) PlanJSON(ctx context.Context, runID string) ([]byte, error) {
+	var run struct {
+		Data tfcRun `json:"data"`
+	}
+	if _, err := t.client.do(ctx, http.MethodGet, "runs/"+url.PathEscape(runID), nil, &run); err != nil {
+		return nil, fmt.Errorf("Failed to get Terraform Cloud run. %s", err)
+	}
+	planID := run.Data.Relationships.Plan.Data.ID
+
+	for {
+		var plan struct {
+			Data tfcPlan `json:"data"`
+		}
+		if _, err := t.client.do(ctx, http.MethodGet, "plans/"+url.PathEscape(planID), nil, &plan); err != nil {
+			return nil, fmt.Errorf("Failed to get Terraform Cloud plan. %s", err)
+		}
+
+		status := plan.Data.Attributes.Status
+		if status == "finished" {
+			break
+		}
+		if status == "errored" || status == "canceled" || status == "unreachable" {
+			return nil, fmt.Errorf("Plan of Terraform Cloud run %s is %s", runID, status)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"run":    runID,
+			"status": status,
+		}).Debug("Waiting for the Terraform Cloud plan")
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Failed to wait for Terraform Cloud run %s. %s", runID, ctx.Err())
+		case <-time.After(t.poll):
+		}
+	}
+
+	// the JSON plan redirects to a temporary URL on another host, which does not get the
+	// token since Go drops it following the redirect
+	var out json.RawMessage
+	if _, err := t.client.do(ctx, http.MethodGet, "plans/"+url.PathEscape(planID)+"/json-output", nil, &out); err != nil {
+		return nil, fmt.Errorf("Failed to get Terraform Cloud JSON plan. %s", err)
+	}
+
+	return out, nil
+}
+
+// loadRemotePlan renders the plan of the Terraform Cloud run, found by commit unless the
+// run ID is given
+func (p Plugin) loadRemotePlan() (string, *parser.Plan, error) {
+	base, err := caTransport(p.Config.caBundle)
+	if err != nil {
+		return "", nil, err
+	}
+	client := &http.Client{
+		Transport: NewRetryTransport(base, p.Config.MaxRetries, p.Config.Timeout),
+	}
+	tfc, err := NewTerraformCloud(p.Config.TFCAddress, p.Config.TFCToken, client)
+	if err != nil {
+		return "", nil, err
+	}
+
+	timeout := p.Config.TFCTimeout
+	if timeout <= 0 {
+		timeout = defaultTFCTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	runID := p.Config.TFCRunID
+	if runID == "" {
+		if p.Config.TFCOrganization == "" {
+			return "", nil, fmt.Errorf("You must provide the Terraform Cloud organization of the workspace")
+		}
+		runID, err = tfc.FindRun(ctx, p.Config.TFCOrganization, p.Config.TFCWorkspace, p.Config.CommitSha)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"run": runID,
+	}).Info("Rendering the plan of the Terraform Cloud run, skipping terraform init")
+
+	b, err := tfc.PlanJSON(ctx, runID)
+	if err != nil {
+		return "", nil, err
+	}
+	planJSON, err := parser.ParsePlan(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return parser.RenderPlan(planJSON), planJSON, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+// fakeTFC is an in-memory stand-in for the parts of the Terraform Cloud API used to get
+// the plan of a run
+type fakeTFC struct {
+	planStatuses []string
+	planChecks   int
+	runsPerPage  int
+	runPages     []string
+}
+
+func (f *fakeTFC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/archivist/plan.json" {
+		w.Write([]byte(execPlanJSON))
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer tfc-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	write := func(v interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": v})
+	}
+	run := func(id, plan, cv string, planOnly bool) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         id,
+			"attributes": map[string]interface{}{"plan-only": planOnly},
+			"relationships": map[string]interface{}{
+				"plan":                  map[string]interface{}{"data": map[string]string{"id": plan}},
+				"configuration-version": map[string]interface{}{"data": map[string]string{"id": cv}},
+			},
+		}
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v2/") {
+	case "organizations/acme/workspaces/prod":
+		write(map[string]string{"id": "ws-1"})
+	case "organizations/acme/workspaces/staging":
+		write(map[string]string{"id": "ws-2"})
+	case "workspaces/ws-2/runs":
+		f.listRuns(w, r, []interface{}{run("run-apply", "plan-apply", "cv-apply", false)})
+	case "workspaces/ws-1/runs":
+		f.listRuns(w, r, []interface{}{
+			run("run-cli", "plan-cli", "cv-cli", true),
+			run("run-apply", "plan-apply", "cv-apply", false),
+			run("run-other", "plan-other", "cv-other", true),
+			run("run-abc", "plan-abc", "cv-abc", true),
+		})
+	case "runs/run-abc":
+		write(run("run-abc", "plan-abc", "cv-abc", true))
+	case "plans/plan-abc":
+		status := "finished"
+		if f.planChecks < len(f.planStatuses) {
+			status = f.planStatuses[f.planChecks]
+		}
+		f.planChecks++
+		write(map[string]interface{}{"attributes": map[string]string{"status": status}})
+	case "plans/plan-abc/json-output":
+		http.Redirect(w, r, "/archivist/plan.json", http.StatusTemporaryRedirect)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// listRuns pages through the runs, including the configuration versions and ingress
+// attributes of the page when asked to. Configurations uploaded by the CLI have none.
+func (f *fakeTFC) listRuns(w http.ResponseWriter, r *http.Request, runs []interface{}) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page[number]"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("page[size]"))
+	if f.runsPerPage > 0 {
+		size = f.runsPerPage
+	}
+	f.runPages = append(f.runPages, r.URL.Query().Get("page[number]"))
+
+	start := (page - 1) * size
+	end := start + size
+	next := page + 1
+	if end >= len(runs) {
+		end = len(runs)
+		next = 0
+	}
+
+	var included []interface{}
+	if r.URL.Query().Get("include") == "configuration_version.ingress_attributes" {
+		for _, run := range runs[start:end] {
+			cv := run.(map[string]interface{})["relationships"].(map[string]interface{})["configuration-version"].(map[string]interface{})["data"].(map[string]string)["id"]
+			version := map[string]interface{}{"id": cv, "type": "configuration-versions"}
+			if cv != "cv-cli" {
+				version["relationships"] = map[string]interface{}{"ingress-attributes": map[string]interface{}{"data": map[string]string{"id": "ia-" + cv}}}
+				included = append(included, map[string]interface{}{
+					"id":         "ia-" + cv,
+					"type":       "ingress-attributes",
+					"attributes": map[string]string{"commit-sha": map[string]string{"cv-other": "def456", "cv-abc": "abc123"}[cv]},
+				})
+			}
+			included = append(included, version)
+		}
+	}
+
+	pagination := map[string]interface{}{"current-page": page, "next-page": nil}
+	if next > 0 {
+		pagination["next-page"] = next
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     runs[start:end],
+		"included": included,
+		"meta":     map[string]interface{}{"pagination": pagination},
+	})
+}
+
+func TestTerraformCloud(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("TerraformCloud", func() {
+		var fake *fakeTFC
+		var server *httptest.Server
+		var tfc *TerraformCloud
+
+		g.BeforeEach(func() {
+			fake = &fakeTFC{}
+			server = httptest.NewServer(fake)
+			tfc, _ = NewTerraformCloud(server.URL, "tfc-token", server.Client())
+			tfc.poll = 0
+		})
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("finds the speculative run of the commit", func() {
+			runID, err := tfc.FindRun(context.Background(), "acme", "prod", "abc123")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(runID).Equal("run-abc")
+			g.Assert(fake.runPages).Equal([]string{"1"})
+		})
+
+		g.It("pages through the runs until the commit is found", func() {
+			fake.runsPerPage = 1
+
+			runID, err := tfc.FindRun(context.Background(), "acme", "prod", "def456")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(runID).Equal("run-other")
+			g.Assert(fake.runPages).Equal([]string{"1", "2", "3"})
+		})
+
+		g.It("finds the latest speculative run without a commit", func() {
+			runID, err := tfc.FindRun(context.Background(), "acme", "prod", "")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(runID).Equal("run-cli")
+		})
+
+		g.It("errors when no run matches the commit", func() {
+			fake.runsPerPage = 3
+
+			_, err := tfc.FindRun(context.Background(), "acme", "prod", "fff000")
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(err.Error()).Equal("No speculative run found in Terraform Cloud workspace acme/prod for commit fff000")
+			g.Assert(fake.runPages).Equal([]string{"1", "2"})
+		})
+
+		g.It("errors without a commit when there is no speculative run", func() {
+			_, err := tfc.FindRun(context.Background(), "acme", "staging", "")
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(err.Error()).Equal("No speculative run found in Terraform Cloud workspace acme/staging")
+		})
+
+		g.It("waits for the plan and returns the JSON plan", func() {
+			fake.planStatuses = []string{"queued", "running"}
+			b, err := tfc.PlanJSON(context.Background(), "run-abc")
+			g.Assert(err == nil).IsTrue("should not error")
+			g.Assert(string(b)).Equal(execPlanJSON)
+			g.Assert(fake.planChecks).Equal(3)
+		})
+
+		g.It("errors when the plan failed", func() {
+			fake.planStatuses = []string{"running", "errored"}
+			_, err := tfc.PlanJSON(context.Background(), "run-abc")
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(err.Error()).Equal("Plan of Terraform Cloud run run-abc is errored")
+		})
+
+		g.It("requires a token", func() {
+			_, err := NewTerraformCloud(server.URL, "", server.Client())
+			g.Assert(err != nil).IsTrue("should have received error")
+		})
+	})
+}
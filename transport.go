@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	headerRetryAfter     = "Retry-After"
+	headerRateRemaining  = "X-RateLimit-Remaining"
+	headerRateReset      = "X-RateLimit-Reset"
+	defaultRetryAttempts = 5
+	defaultRetryTimeout  = 5 * time.Minute
+	defaultRetryBackoff  = time.Second
+	maxRetryBackoff      = time.Minute
+)
+
+type (
+	// RetryTransport retries API requests that failed because of rate limiting, abuse
+	// detection or server errors
+	RetryTransport struct {
+		Base       http.RoundTripper
+		MaxRetries int
+		Timeout    time.Duration
+		Backoff    time.Duration
+
+		now   func() time.Time
+		sleep func(context.Context, time.Duration) error
+	}
+)
+
+// NewRetryTransport wraps base with retries, using http.DefaultTransport when base is nil
+func NewRetryTransport(base http.RoundTripper, maxRetries int, timeout time.Duration) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	if timeout <= 0 {
+		timeout = defaultRetryTimeout
+	}
+
+	return &RetryTransport{
+		Base:       base,
+		MaxRetries: maxRetries,
+		Timeout:    timeout,
+		Backoff:    defaultRetryBackoff,
+		now:        time.Now,
+		sleep:      sleepContext,
+	}
+}
+
+// RoundTrip executes the request, retrying with exponential backoff until it
+// succeeds, the retries are exhausted or the overall timeout is reached. Requests that
+// are not idempotent, like creating a comment, are only retried when rate limited since
+// the server may have processed them otherwise. The timeout is a deadline for every
+// attempt and wait, so a hung connection does not block the build.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	deadline := t.now().Add(t.Timeout)
+	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
+
+	for attempt := 0; ; attempt++ {
+		r := req.WithContext(ctx)
+		if attempt > 0 {
+			r = req.Clone(ctx)
+			if req.Body != nil {
+				if req.GetBody == nil {
+					cancel()
+					return nil, fmt.Errorf("Cannot retry %s %s, request body is not rewindable", req.Method, req.URL)
+				}
+				body, err := req.GetBody()
+				if err != nil {
+					cancel()
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.Base.RoundTrip(r)
+
+		wait, retry := t.retryAfter(resp, err, attempt)
+		if !isIdempotent(req.Method) && !isRateLimited(resp) {
+			retry = false
+		}
+		if ctx.Err() != nil || !retry || attempt >= t.MaxRetries {
+			return withCancel(resp, cancel), err
+		}
+		if t.now().Add(wait).After(deadline) {
+			logrus.WithFields(logrus.Fields{
+				"wait":    wait,
+				"timeout": t.Timeout,
+			}).Warn("API retry would exceed the timeout, giving up")
+			return withCancel(resp, cancel), err
+		}
+
+		fields := logrus.Fields{
+			"attempt": attempt + 1,
+			"wait":    wait,
+			"url":     req.URL.String(),
+		}
+		if err != nil {
+			fields["error"] = err
+		} else {
+			fields["status"] = resp.StatusCode
+			drainBody(resp)
+		}
+		logrus.WithFields(fields).Warn("API request failed, retrying")
+
+		if err := t.sleep(ctx, wait); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+}
+
+// cancelBody releases the deadline of the request once the response body is closed,
+// since the body is read after RoundTrip returns
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func withCancel(resp *http.Response, cancel context.CancelFunc) *http.Response {
+	if resp == nil || resp.Body == nil {
+		cancel()
+		return resp
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// isRateLimited tells whether the response rejected the request for rate limiting, so
+// it was not processed
+func isRateLimited(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusForbidden &&
+		(resp.Header.Get(headerRateRemaining) == "0" || resp.Header.Get(headerRetryAfter) != "")
+}
+
+// sleepContext waits for the duration, returning early when the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryAfter decides whether a response should be retried and how long to wait before doing so
+func (t *RetryTransport) retryAfter(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		return t.backoff(attempt), true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden && resp.Header.Get(headerRateRemaining) == "0":
+		// primary rate limit, wait until the window resets
+		if reset, err := strconv.ParseInt(resp.Header.Get(headerRateReset), 10, 64); err == nil {
+			wait := time.Unix(reset, 0).Sub(t.now()) + time.Second
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+		return t.backoff(attempt), true
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		// abuse/secondary rate limit, only retried when the server tells us when to come back
+		if v := resp.Header.Get(headerRetryAfter); v != "" {
+			if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+		return 0, resp.StatusCode == http.StatusTooManyRequests
+	case resp.StatusCode >= 500:
+		return t.backoff(attempt), true
+	}
+
+	return 0, false
+}
+
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	wait := time.Duration(float64(t.Backoff) * math.Pow(2, float64(attempt)))
+	if wait > maxRetryBackoff || wait <= 0 {
+		wait = maxRetryBackoff
+	}
+
+	return wait
+}
+
+func drainBody(resp *http.Response) {
+	if resp.Body == nil {
+		return
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+)
+
+func TestRetryTransport(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("RetryTransport", func() {
+		var (
+			calls  int
+			waits  []time.Duration
+			now    time.Time
+			server *httptest.Server
+		)
+
+		newTransport := func(handler http.HandlerFunc, maxRetries int, timeout time.Duration) *RetryTransport {
+			calls = 0
+			waits = nil
+			now = time.Unix(1600000000, 0)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				handler(w, r)
+			}))
+
+			tr := NewRetryTransport(nil, maxRetries, timeout)
+			tr.now = func() time.Time { return now }
+			tr.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				now = now.Add(d)
+				return ctx.Err()
+			}
+			return tr
+		}
+
+		get := func(tr *RetryTransport) *http.Response {
+			resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+			g.Assert(err == nil).IsTrue("request should not error")
+			return resp
+		}
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("does not retry successful requests", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {}, 3, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(calls).Equal(1)
+		})
+
+		g.It("does not retry client errors", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			}, 3, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusNotFound)
+			g.Assert(calls).Equal(1)
+		})
+
+		g.It("retries server errors with exponential backoff", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				if calls < 3 {
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			}, 5, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(calls).Equal(3)
+			g.Assert(waits).Equal([]time.Duration{time.Second, 2 * time.Second})
+		})
+
+		g.It("gives up once retries are exhausted", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}, 2, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusInternalServerError)
+			g.Assert(calls).Equal(3)
+		})
+
+		g.It("waits until the rate limit resets", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				if calls == 1 {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+					w.WriteHeader(http.StatusForbidden)
+				}
+			}, 3, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(waits).Equal([]time.Duration{31 * time.Second})
+		})
+
+		g.It("honors Retry-After on abuse rate limits", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				if calls == 1 {
+					w.Header().Set("Retry-After", "7")
+					w.WriteHeader(http.StatusForbidden)
+				}
+			}, 3, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(waits).Equal([]time.Duration{7 * time.Second})
+		})
+
+		g.It("does not retry plain forbidden responses", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}, 3, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusForbidden)
+			g.Assert(calls).Equal(1)
+		})
+
+		g.It("stops when the wait would exceed the timeout", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "120")
+				w.WriteHeader(http.StatusForbidden)
+			}, 3, time.Minute)
+			resp := get(tr)
+			g.Assert(resp.StatusCode).Equal(http.StatusForbidden)
+			g.Assert(calls).Equal(1)
+			g.Assert(len(waits)).Equal(0)
+		})
+
+		g.It("replays the request body on retries", func() {
+			var bodies []string
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(b))
+				if calls == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}, 3, time.Minute)
+			req, _ := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("plan"))
+			resp, err := (&http.Client{Transport: tr}).Do(req)
+			g.Assert(err == nil).IsTrue("request should not error")
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(bodies).Equal([]string{"plan", "plan"})
+		})
+
+		g.It("does not retry POST requests failing with server errors", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}, 3, time.Minute)
+			resp, err := (&http.Client{Transport: tr}).Post(server.URL, "text/plain", strings.NewReader("plan"))
+			g.Assert(err == nil).IsTrue("request should not error")
+			g.Assert(resp.StatusCode).Equal(http.StatusBadGateway)
+			g.Assert(calls).Equal(1)
+		})
+
+		g.It("retries rate limited POST requests", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				if calls == 1 {
+					w.Header().Set("Retry-After", "3")
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}, 3, time.Minute)
+			resp, err := (&http.Client{Transport: tr}).Post(server.URL, "text/plain", strings.NewReader("plan"))
+			g.Assert(err == nil).IsTrue("request should not error")
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(calls).Equal(2)
+		})
+
+		g.It("does not change the request of the caller", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				if calls == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}, 3, time.Minute)
+			req, _ := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("plan"))
+			body := req.Body
+			resp, err := tr.RoundTrip(req)
+			g.Assert(err == nil).IsTrue("request should not error")
+			g.Assert(resp.StatusCode).Equal(http.StatusOK)
+			g.Assert(req.Body == body).IsTrue("should keep the body of the request")
+		})
+
+		g.It("times out requests that hang", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}, 3, 100*time.Millisecond)
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+			start := time.Now()
+			_, err := tr.RoundTrip(req)
+			g.Assert(err != nil).IsTrue("should have received error")
+			g.Assert(time.Since(start) < 5*time.Second).IsTrue("should give up at the timeout")
+			g.Assert(calls).Equal(1)
+		})
+
+		g.It("stops waiting when the request is canceled", func() {
+			tr := newTransport(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}, 3, time.Minute)
+			tr.sleep = sleepContext
+			ctx, cancel := context.WithCancel(context.Background())
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			go cancel()
+			_, err := tr.RoundTrip(req.WithContext(ctx))
+			g.Assert(err != nil).IsTrue("should have received error")
+		})
+	})
+}